@@ -0,0 +1,67 @@
+package slogseq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+)
+
+// Compression is the content encoding used for requests to Seq.
+type Compression int
+
+const (
+	// CompressionNone sends requests uncompressed.
+	CompressionNone Compression = iota
+	// CompressionGzip compresses requests with gzip.
+	CompressionGzip
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	default:
+		return "none"
+	}
+}
+
+// newGzipPool returns a pool of gzip writers using the given compression level.
+func newGzipPool(level int) *sync.Pool {
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		level = gzip.DefaultCompression
+	}
+	return &sync.Pool{
+		New: func() any {
+			zw, _ := gzip.NewWriterLevel(nil, level)
+			return zw
+		},
+	}
+}
+
+// writeBody writes CLEF lines to dst, compressing them if configured to, and
+// returns the value for the Content-Encoding header.
+func (h *SeqHandler) writeBody(dst *bytes.Buffer, lines [][]byte) (string, error) {
+	if h.compression != CompressionGzip {
+		for _, line := range lines {
+			dst.Write(line)
+		}
+		return "", nil
+	}
+
+	if h.gzipPool == nil {
+		h.gzipPool = newGzipPool(gzip.DefaultCompression)
+	}
+	zw := h.gzipPool.Get().(*gzip.Writer)
+	defer h.gzipPool.Put(zw)
+
+	zw.Reset(dst)
+	for _, line := range lines {
+		if _, err := zw.Write(line); err != nil {
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return "gzip", nil
+}
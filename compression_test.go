@@ -0,0 +1,102 @@
+package slogseq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents(n int) []CLEFEvent {
+	events := make([]CLEFEvent, n)
+	for i := range events {
+		events[i] = CLEFEvent{
+			Timestamp: time.Date(2025, 1, 1, 12, 0, i, 0, time.UTC),
+			Message:   "Handled request",
+			Level:     CLEFLevelInformation.String(),
+			Properties: map[string]any{
+				"method":   "GET",
+				"path":     fmt.Sprintf("/api/items/%d", i),
+				"status":   200,
+				"duration": 1.5 * float64(i),
+				"service":  map[string]any{"name": "api", "version": "1.0.0"},
+			},
+		}
+	}
+	return events
+}
+
+func TestCompressionGzip(t *testing.T) {
+	seq := newFakeSeq(201)
+	handler := newSeqHandler("http://example.com")
+	handler = WithCompression(CompressionGzip, gzip.BestSpeed).apply(handler)
+	handler.client = seq.client()
+
+	events := testEntries(t, testEvents(20)...)
+	_, err := handler.attemptSendBatch(context.Background(), nil, events)
+	require.NoError(t, err)
+
+	req := seq.received()[0]
+	assert.Equal(t, "gzip", req.header.Get("Content-Encoding"))
+	assert.Equal(t, "application/vnd.serilog.clef", req.header.Get("Content-Type"))
+
+	zr, err := gzip.NewReader(bytes.NewReader(req.body))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Join(entryLines(events), nil), decoded)
+	assert.Less(t, seq.bytesSent(), int64(len(decoded)))
+}
+
+func TestCompressionNone(t *testing.T) {
+	seq := newFakeSeq(201)
+	handler := newSeqHandler("http://example.com")
+	handler.client = seq.client()
+
+	_, err := handler.attemptSendBatch(context.Background(), nil, testEntries(t, testEvents(1)...))
+	require.NoError(t, err)
+	assert.Empty(t, seq.received()[0].header.Get("Content-Encoding"))
+}
+
+func TestCompressionInvalidLevel(t *testing.T) {
+	pool := newGzipPool(42)
+	assert.NotNil(t, pool.Get())
+}
+
+// BenchmarkAttemptSendBatch compares CPU time and bytes on the wire for a
+// typical batch with and without compression.
+func BenchmarkAttemptSendBatch(b *testing.B) {
+	events := testEntries(b, testEvents(50)...)
+	cases := []struct {
+		name        string
+		compression Compression
+		level       int
+	}{
+		{"none", CompressionNone, 0},
+		{"gzip-speed", CompressionGzip, gzip.BestSpeed},
+		{"gzip-default", CompressionGzip, gzip.DefaultCompression},
+		{"gzip-best", CompressionGzip, gzip.BestCompression},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			seq := newFakeSeq(201)
+			handler := newSeqHandler("http://example.com")
+			handler = WithCompression(c.compression, c.level).apply(handler)
+			handler.client = seq.client()
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := handler.attemptSendBatch(context.Background(), nil, events); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(seq.bytesSent())/float64(b.N), "wire-bytes/op")
+		})
+	}
+}
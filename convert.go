@@ -0,0 +1,579 @@
+package slogseq
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// A ValueConverter replaces property values before they are encoded, for
+// types that should be written differently than by the default conversion.
+// It returns the value to write instead and true, or false to leave v alone.
+//
+// Converters are called for every property value, including the elements of
+// maps, slices and structs, but not again for the value a converter returned.
+type ValueConverter func(v any) (any, bool)
+
+// valueEncoder writes property values as JSON. The slog kinds and common
+// types are written directly, anything else is converted as follows:
+//
+//   - time.Duration as a string like "1.5s"
+//   - []byte as a string if it is valid UTF-8, or in hex otherwise
+//   - NaN and infinite floats as the strings "NaN", "+Inf" and "-Inf"
+//   - errors as their message, json.Marshaler with its own encoding, then
+//     encoding.TextMarshaler and fmt.Stringer as strings
+//   - maps, slices, arrays, pointers and structs (honouring json tags) by
+//     reflection, with cycles written as "!CYCLE:<type>"
+//   - channels and functions as "!UNSUPPORTED:<type>"
+//
+// Failing or panicking methods are written as "!ERROR:<error>" and
+// "!PANIC:<value>", so a value never keeps an event from being encoded.
+type valueEncoder struct {
+	converters []ValueConverter
+	// maps, slices and pointers being written, to detect cycles
+	visiting []visit
+
+	limits  Limits
+	limited bool     // whether there are any limits
+	depth   int      // nesting of the value being written
+	path    []string // keys of the value being written, with limits
+	report  limitReport
+}
+
+type visit struct {
+	ptr uintptr
+	len int
+}
+
+// enter marks rv as being written. It returns false if it already is, which
+// means the value contains itself.
+func (enc *valueEncoder) enter(rv reflect.Value) bool {
+	v := visit{ptr: rv.Pointer()}
+	if rv.Kind() == reflect.Slice {
+		v.len = rv.Len()
+	}
+	if slices.Contains(enc.visiting, v) {
+		return false
+	}
+	enc.visiting = append(enc.visiting, v)
+	return true
+}
+
+func (enc *valueEncoder) leave() {
+	enc.visiting = enc.visiting[:len(enc.visiting)-1]
+}
+
+// appendValue appends v as JSON.
+func (enc *valueEncoder) appendValue(buf []byte, v slog.Value) []byte {
+	if v.Kind() == slog.KindLogValuer {
+		v = v.Resolve()
+	}
+	if len(enc.converters) > 0 && v.Kind() != slog.KindGroup {
+		// converters see every value, so go the slow way
+		return enc.appendAny(buf, v.Any())
+	}
+	switch v.Kind() {
+	case slog.KindString:
+		return enc.appendStringValue(buf, v.String())
+	case slog.KindInt64:
+		return strconv.AppendInt(buf, v.Int64(), 10)
+	case slog.KindUint64:
+		return strconv.AppendUint(buf, v.Uint64(), 10)
+	case slog.KindFloat64:
+		return appendFloat(buf, v.Float64(), 64)
+	case slog.KindBool:
+		return strconv.AppendBool(buf, v.Bool())
+	case slog.KindDuration:
+		return appendString(buf, v.Duration().String())
+	case slog.KindTime:
+		return appendTime(buf, v.Time())
+	case slog.KindGroup:
+		return enc.appendGroup(buf, v.Group())
+	default:
+		return enc.appendAny(buf, v.Any())
+	}
+}
+
+func (enc *valueEncoder) appendGroup(buf []byte, attrs []slog.Attr) []byte {
+	if !enc.nest() {
+		return appendString(buf, depthMarker)
+	}
+	defer enc.unnest()
+	buf = append(buf, '{')
+	for i, a := range attrs[:enc.elements(len(attrs))] {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendString(buf, a.Key)
+		buf = append(buf, ':')
+		enc.push(a.Key)
+		buf = enc.appendValue(buf, a.Value)
+		enc.pop()
+	}
+	return append(buf, '}')
+}
+
+// appendAny appends an arbitrary value as JSON, such as the values of
+// CLEFEvent.Properties, after running it through the converters.
+func (enc *valueEncoder) appendAny(buf []byte, v any) []byte {
+	for _, convert := range enc.converters {
+		if c, ok := convert(v); ok {
+			v = c
+			break
+		}
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case string:
+		return enc.appendStringValue(buf, v)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case uint64:
+		return strconv.AppendUint(buf, v, 10)
+	case float64:
+		return appendFloat(buf, v, 64)
+	case time.Duration:
+		return appendString(buf, v.String())
+	case time.Time:
+		return appendTime(buf, v)
+	case []byte:
+		return enc.appendBytes(buf, v)
+	case slog.Value:
+		return enc.appendValue(buf, v)
+	case slog.LogValuer:
+		return enc.appendValue(buf, slog.AnyValue(v).Resolve())
+	case *slog.Source:
+		if v == nil {
+			return append(buf, "null"...)
+		}
+		buf = append(buf, `{"function":`...)
+		buf = appendString(buf, v.Function)
+		buf = append(buf, `,"file":`...)
+		buf = appendString(buf, v.File)
+		buf = append(buf, `,"line":`...)
+		buf = strconv.AppendInt(buf, int64(v.Line), 10)
+		return append(buf, '}')
+	case map[string]any:
+		rv := reflect.ValueOf(v)
+		if v == nil {
+			return append(buf, "null"...)
+		}
+		if !enc.enter(rv) {
+			return appendString(buf, "!CYCLE:"+rv.Type().String())
+		}
+		defer enc.leave()
+		if !enc.nest() {
+			return appendString(buf, depthMarker)
+		}
+		defer enc.unnest()
+		// sorted like encoding/json does, so the output is stable
+		keys := slices.Sorted(maps.Keys(v))
+		buf = append(buf, '{')
+		for i, k := range keys[:enc.elements(len(keys))] {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendString(buf, k)
+			buf = append(buf, ':')
+			enc.push(k)
+			buf = enc.appendAny(buf, v[k])
+			enc.pop()
+		}
+		return append(buf, '}')
+	case []any:
+		rv := reflect.ValueOf(v)
+		if v == nil {
+			return append(buf, "null"...)
+		}
+		if !enc.enter(rv) {
+			return appendString(buf, "!CYCLE:"+rv.Type().String())
+		}
+		defer enc.leave()
+		if !enc.nest() {
+			return appendString(buf, depthMarker)
+		}
+		defer enc.unnest()
+		buf = append(buf, '[')
+		for i, e := range v[:enc.elements(len(v))] {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = enc.appendAny(buf, e)
+		}
+		return append(buf, ']')
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && rv.IsNil() {
+		// don't call methods on nil pointers
+		return append(buf, "null"...)
+	}
+	switch v.(type) {
+	case error, json.Marshaler, encoding.TextMarshaler, fmt.Stringer:
+		return enc.appendMarshaled(buf, v)
+	}
+	return enc.appendReflect(buf, rv)
+}
+
+// appendMarshaled appends a value that knows how to format itself.
+func (enc *valueEncoder) appendMarshaled(buf []byte, v any) (out []byte) {
+	start := len(buf)
+	defer func() {
+		if r := recover(); r != nil {
+			out = appendString(buf[:start], fmt.Sprintf("!PANIC:%v", r))
+		}
+	}()
+
+	switch v := v.(type) {
+	case error:
+		return enc.appendStringValue(buf, v.Error())
+	case json.Marshaler:
+		b, err := v.MarshalJSON()
+		if err != nil {
+			return appendString(buf, "!ERROR:"+err.Error())
+		}
+		// checks that it is valid JSON, too
+		compacted := bytes.NewBuffer(buf)
+		if err := json.Compact(compacted, b); err != nil {
+			return appendString(buf, "!ERROR:"+err.Error())
+		}
+		return compacted.Bytes()
+	case encoding.TextMarshaler:
+		b, err := v.MarshalText()
+		if err != nil {
+			return appendString(buf, "!ERROR:"+err.Error())
+		}
+		return enc.appendBytes(buf, b)
+	case fmt.Stringer:
+		return enc.appendStringValue(buf, v.String())
+	}
+	return buf
+}
+
+// appendReflect appends the values of other types.
+func (enc *valueEncoder) appendReflect(buf []byte, rv reflect.Value) []byte {
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return append(buf, "null"...)
+	case reflect.Bool:
+		return strconv.AppendBool(buf, rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(buf, rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(buf, rv.Uint(), 10)
+	case reflect.Float32:
+		return appendFloat(buf, rv.Float(), 32)
+	case reflect.Float64:
+		return appendFloat(buf, rv.Float(), 64)
+	case reflect.Complex64:
+		return appendString(buf, strconv.FormatComplex(rv.Complex(), 'g', -1, 64))
+	case reflect.Complex128:
+		return appendString(buf, strconv.FormatComplex(rv.Complex(), 'g', -1, 128))
+	case reflect.String:
+		return enc.appendStringValue(buf, rv.String())
+	case reflect.Interface:
+		if rv.IsNil() {
+			return append(buf, "null"...)
+		}
+		return enc.appendElem(buf, rv.Elem())
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return append(buf, "null"...)
+		}
+		if !enc.enter(rv) {
+			return appendString(buf, "!CYCLE:"+rv.Type().String())
+		}
+		defer enc.leave()
+		return enc.appendElem(buf, rv.Elem())
+	case reflect.Slice:
+		if rv.IsNil() {
+			return append(buf, "null"...)
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return enc.appendBytes(buf, rv.Bytes())
+		}
+		if !enc.enter(rv) {
+			return appendString(buf, "!CYCLE:"+rv.Type().String())
+		}
+		defer enc.leave()
+		return enc.appendArray(buf, rv)
+	case reflect.Array:
+		return enc.appendArray(buf, rv)
+	case reflect.Map:
+		if rv.IsNil() {
+			return append(buf, "null"...)
+		}
+		if !enc.enter(rv) {
+			return appendString(buf, "!CYCLE:"+rv.Type().String())
+		}
+		defer enc.leave()
+		return enc.appendMap(buf, rv)
+	case reflect.Struct:
+		return enc.appendStruct(buf, rv)
+	default:
+		// channels, functions and unsafe pointers
+		return appendString(buf, "!UNSUPPORTED:"+rv.Type().String())
+	}
+}
+
+// appendElem appends an element of a map, slice, array, pointer or struct.
+func (enc *valueEncoder) appendElem(buf []byte, rv reflect.Value) []byte {
+	if rv.CanInterface() {
+		return enc.appendAny(buf, rv.Interface())
+	}
+	return enc.appendReflect(buf, rv)
+}
+
+func (enc *valueEncoder) appendArray(buf []byte, rv reflect.Value) []byte {
+	if !enc.nest() {
+		return appendString(buf, depthMarker)
+	}
+	defer enc.unnest()
+	buf = append(buf, '[')
+	for i := range enc.elements(rv.Len()) {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = enc.appendElem(buf, rv.Index(i))
+	}
+	return append(buf, ']')
+}
+
+// appendMap appends a map as an object, with its keys sorted.
+func (enc *valueEncoder) appendMap(buf []byte, rv reflect.Value) []byte {
+	if !enc.nest() {
+		return appendString(buf, depthMarker)
+	}
+	defer enc.unnest()
+	type kv struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]kv, 0, rv.Len())
+	for it := rv.MapRange(); it.Next(); {
+		entries = append(entries, kv{mapKey(it.Key()), it.Value()})
+	}
+	slices.SortFunc(entries, func(a, b kv) int { return strings.Compare(a.key, b.key) })
+
+	buf = append(buf, '{')
+	for i, e := range entries[:enc.elements(len(entries))] {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = appendString(buf, e.key)
+		buf = append(buf, ':')
+		enc.push(e.key)
+		buf = enc.appendElem(buf, e.value)
+		enc.pop()
+	}
+	return append(buf, '}')
+}
+
+// mapKey formats a map key the way encoding/json does, and any other key
+// with fmt.
+func mapKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	if k.CanInterface() {
+		if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+			if k.Kind() == reflect.Pointer && k.IsNil() {
+				return ""
+			}
+			if b, err := tm.MarshalText(); err == nil {
+				return string(b)
+			}
+		}
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	if k.CanInterface() {
+		return fmt.Sprint(k.Interface())
+	}
+	return k.String()
+}
+
+func (enc *valueEncoder) appendStruct(buf []byte, rv reflect.Value) []byte {
+	if !enc.nest() {
+		return appendString(buf, depthMarker)
+	}
+	defer enc.unnest()
+	buf = append(buf, '{')
+	first := true
+	for _, f := range cachedFields(rv.Type()) {
+		fv, err := rv.FieldByIndexErr(f.index)
+		if err != nil {
+			continue // through a nil embedded pointer
+		}
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+		buf = appendString(buf, f.name)
+		buf = append(buf, ':')
+		enc.push(f.name)
+		buf = enc.appendElem(buf, fv)
+		enc.pop()
+	}
+	return append(buf, '}')
+}
+
+// structField is an exported field of a struct, named as encoding/json
+// would name it.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldCache sync.Map // reflect.Type -> []structField
+
+func cachedFields(t reflect.Type) []structField {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]structField)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]structField)
+}
+
+// typeFields lists the fields of a struct in declaration order, with the
+// fields of embedded structs promoted unless a shallower field has the same
+// name.
+func typeFields(t reflect.Type) []structField {
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+	var fields []structField
+	names := map[string]bool{}
+	visited := map[reflect.Type]bool{t: true}
+
+	for level := []embedded{{t, nil}}; len(level) > 0; {
+		var next []embedded
+		for _, e := range level {
+			for i := range e.typ.NumField() {
+				sf := e.typ.Field(i)
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(slices.Clip(e.index), i)
+
+				if sf.Anonymous && name == "" {
+					ft := sf.Type
+					if ft.Kind() == reflect.Pointer {
+						ft = ft.Elem()
+					}
+					if ft.Kind() == reflect.Struct {
+						if !visited[ft] {
+							visited[ft] = true
+							next = append(next, embedded{ft, index})
+						}
+						continue
+					}
+				}
+				if !sf.IsExported() {
+					continue
+				}
+				if name == "" {
+					name = sf.Name
+				}
+				if names[name] {
+					continue
+				}
+				names[name] = true
+				fields = append(fields, structField{name: name, index: index, omitEmpty: strings.Contains(opts, "omitempty")})
+			}
+		}
+		level = next
+	}
+
+	slices.SortFunc(fields, func(a, b structField) int { return slices.Compare(a.index, b.index) })
+	return fields
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// appendFloat formats floats the way encoding/json does, and NaN and the
+// infinities, which JSON has no numbers for, as strings.
+func appendFloat(buf []byte, f float64, bits int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(buf, `"NaN"`...)
+	case math.IsInf(f, 1):
+		return append(buf, `"+Inf"`...)
+	case math.IsInf(f, -1):
+		return append(buf, `"-Inf"`...)
+	}
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	buf = strconv.AppendFloat(buf, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		if n := len(buf); n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf
+}
+
+func appendTime(buf []byte, t time.Time) []byte {
+	buf = append(buf, '"')
+	buf = t.AppendFormat(buf, time.RFC3339Nano)
+	return append(buf, '"')
+}
+
+// appendBytes appends b as a string if it is text, and in hex otherwise.
+func (enc *valueEncoder) appendBytes(buf []byte, b []byte) []byte {
+	if b == nil {
+		return append(buf, "null"...)
+	}
+	if utf8.Valid(b) {
+		return enc.appendStringValue(buf, string(b))
+	}
+	return enc.appendStringValue(buf, hex.EncodeToString(b))
+}
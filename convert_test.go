@@ -0,0 +1,163 @@
+package slogseq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendFloat_MatchesEncodingJSON(t *testing.T) {
+	for _, f := range []float64{0, 1, -1.5, 3.14159, 1e-7, 1e20, 1e21, 123456789.125, -2.5e-10, math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		expected, err := json.Marshal(f)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(appendFloat(nil, f, 64)), "float %v", f)
+	}
+	for _, f := range []float32{0.1, -2.5, 1e-7, 3.4e38} {
+		expected, err := json.Marshal(f)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(appendFloat(nil, float64(f), 32)), "float32 %v", f)
+	}
+
+	assert.Equal(t, `"NaN"`, string(appendFloat(nil, math.NaN(), 64)))
+	assert.Equal(t, `"+Inf"`, string(appendFloat(nil, math.Inf(1), 64)))
+	assert.Equal(t, `"-Inf"`, string(appendFloat(nil, math.Inf(-1), 32)))
+}
+
+type textValue struct{ A, B int }
+
+type stringerValue struct{ id int }
+
+func (s stringerValue) String() string { return fmt.Sprintf("item-%d", s.id) }
+
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalJSON() ([]byte, error) { return nil, errors.New("no way") }
+
+type panickingStringer struct{}
+
+func (panickingStringer) String() string { panic("boom") }
+
+type node struct {
+	Name string
+	Next *node
+}
+
+type Base struct {
+	ID   int
+	Kind string
+}
+
+type tagged struct {
+	Base
+	Kind    string
+	Renamed string `json:"renamed_field"`
+	Skipped string `json:"-"`
+	Empty   string `json:",omitempty"`
+	private string
+}
+
+func TestAppendValue(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	cyclic := &node{Name: "a"}
+	cyclic.Next = &node{Name: "b", Next: cyclic}
+	selfMap := map[string]any{"name": "m"}
+	selfMap["self"] = selfMap
+	shared := &node{Name: "shared"}
+
+	cases := []struct {
+		value    slog.Value
+		expected string
+	}{
+		{slog.StringValue("s"), `"s"`},
+		{slog.Int64Value(-42), `-42`},
+		{slog.Uint64Value(42), `42`},
+		{slog.Float64Value(1.5), `1.5`},
+		{slog.Float64Value(math.NaN()), `"NaN"`},
+		{slog.BoolValue(false), `false`},
+		{slog.DurationValue(1500 * time.Millisecond), `"1.5s"`},
+		{slog.AnyValue([]time.Duration{time.Minute}), `["1m0s"]`},
+		{slog.TimeValue(ts), `"2025-01-02T03:04:05.000000006Z"`},
+		{slog.GroupValue(slog.Int("a", 1), slog.Group("b", slog.String("c", "d"))), `{"a":1,"b":{"c":"d"}}`},
+		{slog.AnyValue(nil), `null`},
+		{slog.AnyValue(fmt.Errorf("failed")), `"failed"`},
+		{slog.AnyValue(&slog.Source{Function: "f", File: "x.go", Line: 3}), `{"function":"f","file":"x.go","line":3}`},
+		{slog.AnyValue([]any{1, "two", 3.5, math.Inf(1)}), `[1,"two",3.5,"+Inf"]`},
+		{slog.AnyValue(map[string]any{"k": "v"}), `{"k":"v"}`},
+		{slog.AnyValue(textValue{1, 2}), `{"A":1,"B":2}`},
+		{slog.AnyValue([]string{"x", "y"}), `["x","y"]`},
+		{slog.AnyValue([]byte("text")), `"text"`},
+		{slog.AnyValue([]byte{0xde, 0xad, 0xbe, 0xef}), `"deadbeef"`},
+		{slog.AnyValue(json.RawMessage(`{"raw": true}`)), `{"raw":true}`},
+		{slog.AnyValue(net.IPv4(10, 0, 0, 1)), `"10.0.0.1"`},
+		{slog.AnyValue(stringerValue{7}), `"item-7"`},
+		{slog.AnyValue(map[int]stringerValue{2: {2}, 1: {1}}), `{"1":"item-1","2":"item-2"}`},
+		{slog.AnyValue((*stringerValue)(nil)), `null`},
+		{slog.AnyValue(failingMarshaler{}), `"!ERROR:no way"`},
+		{slog.AnyValue(panickingStringer{}), `"!PANIC:boom"`},
+		{slog.AnyValue(cyclic), `{"Name":"a","Next":{"Name":"b","Next":"!CYCLE:*slogseq.node"}}`},
+		{slog.AnyValue(selfMap), `{"name":"m","self":"!CYCLE:map[string]interface {}"}`},
+		{slog.AnyValue([]*node{shared, shared}), `[{"Name":"shared","Next":null},{"Name":"shared","Next":null}]`},
+		{slog.AnyValue(make(chan int)), `"!UNSUPPORTED:chan int"`},
+		{slog.AnyValue(func() {}), `"!UNSUPPORTED:func()"`},
+		{slog.AnyValue(complex(1, 2)), `"(1+2i)"`},
+		{slog.AnyValue([]float32{0.1}), `[0.1]`},
+		{slog.AnyValue(tagged{Base: Base{ID: 1, Kind: "base"}, Kind: "outer", Renamed: "r", Skipped: "s", private: "p"}),
+			`{"ID":1,"Kind":"outer","renamed_field":"r"}`},
+	}
+	for _, c := range cases {
+		var enc valueEncoder
+		assert.Equal(t, c.expected, string(enc.appendValue(nil, c.value)), "value %v", c.value)
+		assert.Empty(t, enc.visiting)
+	}
+}
+
+func TestWithValueConverter(t *testing.T) {
+	handler := newQueueingHandler(
+		WithValueConverter(func(v any) (any, bool) {
+			if d, ok := v.(time.Duration); ok {
+				return d.Milliseconds(), true
+			}
+			return nil, false
+		}),
+		WithValueConverter(func(v any) (any, bool) {
+			if s, ok := v.(string); ok && strings.HasPrefix(s, "secret") {
+				return "***", true
+			}
+			return nil, false
+		}),
+	)
+	defer handler.Close()
+
+	slog.New(handler).Info("converted",
+		"elapsed", 1500*time.Millisecond,
+		"nested", map[string]any{"password": "secret123", "timeout": time.Second},
+		"plain", "value",
+	)
+
+	evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+	assert.Equal(t, float64(1500), evt.Properties["elapsed"])
+	assert.Equal(t, map[string]any{"password": "***", "timeout": float64(1000)}, evt.Properties["nested"])
+	assert.Equal(t, "value", evt.Properties["plain"])
+	assert.Equal(t, "converted", evt.Message, "CLEF fields aren't converted")
+}
+
+func TestHandle_UnsupportedValuesDontDropEvents(t *testing.T) {
+	handler := newQueueingHandler()
+	defer handler.Close()
+
+	slog.New(handler).Info("odd values", "nan", math.NaN(), "ch", make(chan int), "bad", failingMarshaler{})
+
+	evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+	assert.Equal(t, "NaN", evt.Properties["nan"])
+	assert.Equal(t, "!UNSUPPORTED:chan int", evt.Properties["ch"])
+	assert.Zero(t, handler.Stats().Workers[0].DroppedUndeliverable)
+}
@@ -0,0 +1,414 @@
+package slogseq
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// entry is an event encoded as a CLEF line, which is what the workers queue,
+// batch, retry and spool.
+type entry struct {
+	line      []byte      // newline-terminated JSON
+	timestamp time.Time   // to purge entries that have been retried for too long
+	span      bool        // a span rather than a log event, sent elsewhere with OTLP
+	otlp      *otlpRecord // the event converted for OTLP, if that is the protocol
+}
+
+// Events are encoded into pooled buffers and copied out once their size is
+// known, so a buffer is only ever used by one goroutine at a time.
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+// maxPooledBuffer keeps buffers grown by a huge event out of the pool.
+const maxPooledBuffer = 64 * 1024
+
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+func putBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBuffer {
+		return
+	}
+	*b = (*b)[:0]
+	bufferPool.Put(b)
+}
+
+// propTree holds the properties of an event as nested objects, in the order
+// they were added. Nodes are kept in a single slice and linked by index, so
+// a pooled tree can be filled without allocating.
+type propTree struct {
+	nodes []propNode
+}
+
+type propNode struct {
+	key   string
+	value slog.Value
+	group bool
+	// children of a group and the next sibling, noNode if there are none
+	first, last, next int32
+}
+
+const (
+	rootNode int32 = 0
+	noNode   int32 = -1
+)
+
+var treePool = sync.Pool{
+	New: func() any { return &propTree{} },
+}
+
+func getPropTree() *propTree {
+	t := treePool.Get().(*propTree)
+	t.nodes = append(t.nodes[:0], propNode{group: true, first: noNode, last: noNode, next: noNode})
+	return t
+}
+
+func putPropTree(t *propTree) {
+	clear(t.nodes) // don't keep the values alive
+	treePool.Put(t)
+}
+
+// child returns the child of parent with the given key, or noNode.
+func (t *propTree) child(parent int32, key string) int32 {
+	for i := t.nodes[parent].first; i != noNode; i = t.nodes[i].next {
+		if t.nodes[i].key == key {
+			return i
+		}
+	}
+	return noNode
+}
+
+// node returns the child of parent with the given key, adding it if needed.
+func (t *propTree) node(parent int32, key string) int32 {
+	if i := t.child(parent, key); i != noNode {
+		return i
+	}
+	i := int32(len(t.nodes))
+	t.nodes = append(t.nodes, propNode{key: key, first: noNode, last: noNode, next: noNode})
+	if p := &t.nodes[parent]; p.last == noNode {
+		p.first, p.last = i, i
+	} else {
+		t.nodes[p.last].next = i
+		p.last = i
+	}
+	return i
+}
+
+// group returns the group with the given key under parent. Like assigning to
+// a map, a value already stored under the key is replaced.
+func (t *propTree) group(parent int32, key string) int32 {
+	i := t.node(parent, key)
+	if n := &t.nodes[i]; !n.group {
+		n.group, n.value, n.first, n.last = true, slog.Value{}, noNode, noNode
+	}
+	return i
+}
+
+// set stores a value under key, replacing whatever was there.
+func (t *propTree) set(parent int32, key string, v slog.Value) {
+	n := &t.nodes[t.node(parent, key)]
+	n.group, n.value, n.first, n.last = false, v, noNode, noNode
+}
+
+// addAttr adds an attribute under parent. Groups become nested objects, and
+// attributes of groups without a key are added to parent itself. If dotted is
+// set, keys are split at dots into nested objects, as is done for the
+// top-level attributes of an event.
+func (t *propTree) addAttr(parent int32, a slog.Attr, dotted bool) {
+	a.Value = a.Value.Resolve()
+
+	if a.Key == "" {
+		// Anonymous group, inline
+		if a.Value.Kind() == slog.KindGroup {
+			for _, ga := range a.Value.Group() {
+				t.addAttr(parent, ga, dotted)
+			}
+		}
+		return
+	}
+
+	key := a.Key
+	if dotted {
+		if i := strings.LastIndexByte(key, '.'); i >= 0 {
+			parent = t.path(parent, key[:i])
+			key = key[i+1:]
+		}
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		g := t.group(parent, key)
+		for _, ga := range a.Value.Group() {
+			t.addAttr(g, ga, false)
+		}
+		return
+	}
+	t.set(parent, key, a.Value)
+}
+
+// path returns the group at a dotted path under parent, such as "http.request".
+func (t *propTree) path(parent int32, dotted string) int32 {
+	for {
+		head, rest, found := strings.Cut(dotted, ".")
+		parent = t.group(parent, head)
+		if !found {
+			return parent
+		}
+		dotted = rest
+	}
+}
+
+// lookup returns the value of a top-level property.
+func (t *propTree) lookup(key string) (any, bool) {
+	i := t.child(rootNode, key)
+	if i == noNode || t.nodes[i].group {
+		return nil, false
+	}
+	return t.nodes[i].value.Any(), true
+}
+
+// has reports whether there is a top-level property with the given key.
+func (t *propTree) has(key string) bool {
+	return t != nil && t.child(rootNode, key) != noNode
+}
+
+// encodeEntry encodes an event with its properties. Events larger than
+// maxEventBytes are truncated if truncateOversized is set, and rejected
+// otherwise.
+func (h *SeqHandler) encodeEntry(e *CLEFEvent, props *propTree) (entry, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	line := h.appendEvent(*buf, e, props)
+	*buf = line // keep the grown buffer
+	if h.maxEventBytes > 0 && len(line) > h.maxEventBytes {
+		if !h.truncateOversized {
+			return entry{}, errEventTooLarge
+		}
+		return h.truncateEvent(e, len(line), h.maxEventBytes)
+	}
+	return h.newEntry(bytes.Clone(line), e, props), nil
+}
+
+// newEntry returns the entry for e, encoded as line.
+func (h *SeqHandler) newEntry(line []byte, e *CLEFEvent, props *propTree) entry {
+	ent := entry{line: line, timestamp: e.Timestamp, span: !e.SpanStart.IsZero()}
+	if h.protocol == ProtocolOTLP {
+		ent.otlp = h.convertOTLP(e, props)
+	}
+	return ent
+}
+
+// appendEvent appends e as a CLEF line to buf, with props as its properties.
+// The CLEF fields come first; e.Properties is ignored.
+func (h *SeqHandler) appendEvent(buf []byte, e *CLEFEvent, props *propTree) []byte {
+	enc := valueEncoder{converters: h.valueConverters, limits: h.limits, limited: h.limits != Limits{}}
+	// with ReservedKeyOverwrite, a property replaces the CLEF field
+	field := func(key string) bool {
+		return h.reservedKeyPolicy != ReservedKeyOverwrite || !props.has(key)
+	}
+
+	buf = append(buf, '{')
+	if field("@t") {
+		buf = append(buf, `"@t":"`...)
+		buf = e.Timestamp.AppendFormat(buf, time.RFC3339Nano)
+		buf = append(buf, `",`...)
+	}
+	if field("@l") {
+		buf = append(buf, `"@l":`...)
+		buf = appendString(buf, e.Level)
+		buf = append(buf, ',')
+	}
+	if (e.MessageTemplate == "" || e.Message != "") && field("@m") {
+		buf = appendStringField(buf, "@m", e.Message)
+	}
+	if e.MessageTemplate != "" && field("@mt") {
+		buf = appendStringField(buf, "@mt", e.MessageTemplate)
+	}
+	if len(e.Renderings) > 0 && field("@r") {
+		buf = append(buf, `"@r":[`...)
+		for i, r := range e.Renderings {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendString(buf, r)
+		}
+		buf = append(buf, "],"...)
+	}
+	if e.EventType != "" && field("@i") {
+		buf = appendStringField(buf, "@i", e.EventType)
+	}
+	if e.Exception != "" && field("@x") {
+		buf = appendStringField(buf, "@x", e.Exception)
+	}
+	if !e.SpanStart.IsZero() && field("@st") {
+		buf = append(buf, `"@st":"`...)
+		buf = e.SpanStart.AppendFormat(buf, time.RFC3339Nano)
+		buf = append(buf, `",`...)
+	}
+	if e.TraceID != "" && field("@tr") {
+		buf = appendStringField(buf, "@tr", e.TraceID)
+	}
+	if e.SpanID != "" && field("@sp") {
+		buf = appendStringField(buf, "@sp", e.SpanID)
+	}
+	if e.ParentSpanID != "" && field("@ps") {
+		buf = appendStringField(buf, "@ps", e.ParentSpanID)
+	}
+	if len(e.ResourceAttributes) > 0 && field("@ra") {
+		buf = append(buf, `"@ra":`...)
+		buf = enc.appendAny(buf, e.ResourceAttributes)
+		buf = append(buf, ',')
+	}
+	if e.SpanKind != "" && field("@sk") {
+		buf = appendStringField(buf, "@sk", e.SpanKind)
+	}
+
+	if props != nil {
+		written := 0
+		for i := props.nodes[rootNode].first; i != noNode; i = props.nodes[i].next {
+			key := props.nodes[i].key
+			if max := h.limits.MaxProperties; max > 0 && written >= max {
+				enc.report.properties++
+				continue
+			}
+			if strings.HasPrefix(key, "@") {
+				// user properties must not clash with the CLEF fields
+				switch h.reservedKeyPolicy {
+				case ReservedKeyDrop:
+					continue
+				case ReservedKeyEscape:
+					buf = append(buf, `"@`...)
+					buf = appendEscaped(buf, key)
+					buf = append(buf, `":`...)
+				default:
+					buf = appendString(buf, key)
+					buf = append(buf, ':')
+				}
+			} else {
+				buf = appendString(buf, key)
+				buf = append(buf, ':')
+			}
+			enc.push(key)
+			buf = props.appendNode(&enc, buf, i)
+			enc.pop()
+			buf = append(buf, ',')
+			written++
+		}
+	}
+	if !enc.report.empty() {
+		buf = append(buf, `"`+limitsKey+`":`...)
+		buf = enc.report.append(buf)
+		buf = append(buf, ',')
+	}
+
+	// replace the trailing comma, there always is one after @t
+	if buf[len(buf)-1] == ',' {
+		buf = buf[:len(buf)-1]
+	}
+	return append(buf, "}\n"...)
+}
+
+func (t *propTree) appendNode(enc *valueEncoder, buf []byte, i int32) []byte {
+	n := &t.nodes[i]
+	if !n.group {
+		return enc.appendValue(buf, n.value)
+	}
+	if !enc.nest() {
+		return appendString(buf, depthMarker)
+	}
+	defer enc.unnest()
+
+	buf = append(buf, '{')
+	written := 0
+	for c := n.first; c != noNode; c = t.nodes[c].next {
+		if max := enc.limits.MaxElements; max > 0 && written == max {
+			enc.note(&enc.report.elements)
+			break
+		}
+		if c != n.first {
+			buf = append(buf, ',')
+		}
+		buf = appendString(buf, t.nodes[c].key)
+		buf = append(buf, ':')
+		enc.push(t.nodes[c].key)
+		buf = t.appendNode(enc, buf, c)
+		enc.pop()
+		written++
+	}
+	return append(buf, '}')
+}
+
+func appendStringField(buf []byte, key, value string) []byte {
+	buf = append(buf, '"')
+	buf = append(buf, key...)
+	buf = append(buf, `":`...)
+	buf = appendString(buf, value)
+	return append(buf, ',')
+}
+
+// appendString appends s as a quoted JSON string.
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	buf = appendEscaped(buf, s)
+	return append(buf, '"')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendEscaped appends s with the escaping of a JSON string, but without the
+// quotes. Invalid UTF-8 is replaced by U+FFFD, as encoding/json does.
+func appendEscaped(buf []byte, s string) []byte {
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				buf = append(buf, '\\', c)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			// valid JSON, but not valid JavaScript
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	return append(buf, s[start:]...)
+}
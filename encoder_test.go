@@ -0,0 +1,300 @@
+package slogseq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeTestEvent encodes an event the way HandleCLEFEvent does.
+func encodeTestEvent(h *SeqHandler, e CLEFEvent) (entry, error) {
+	props := getPropTree()
+	defer putPropTree(props)
+	for k, v := range e.Properties {
+		props.set(rootNode, k, slog.AnyValue(v))
+	}
+	return h.encodeEntry(&e, props)
+}
+
+// testEntries encodes events without any limits.
+func testEntries(t testing.TB, events ...CLEFEvent) []entry {
+	t.Helper()
+	entries := make([]entry, len(events))
+	for i, e := range events {
+		var err error
+		entries[i], err = encodeTestEvent(&SeqHandler{}, e)
+		require.NoError(t, err)
+	}
+	return entries
+}
+
+// newQueueingHandler returns a started handler whose workers only queue
+// events, so tests can read them from the channels.
+func newQueueingHandler(opts ...SeqOption) *SeqHandler {
+	handler := newSeqHandler("http://example.com")
+	for _, opt := range opts {
+		handler = opt.apply(handler)
+	}
+	handler.noFlush = true
+	handler.start()
+	return handler
+}
+
+// decodeEntry turns an encoded entry back into a CLEFEvent, with every
+// property that isn't a CLEF field in Properties.
+func decodeEntry(t testing.TB, e entry) CLEFEvent {
+	t.Helper()
+	var evt CLEFEvent
+	require.NoError(t, json.Unmarshal(e.line, &evt), "line: %s", e.line)
+
+	var all map[string]any
+	require.NoError(t, json.Unmarshal(e.line, &all))
+	for _, field := range []string{"@t", "@m", "@mt", "@r", "@i", "@x", "@l", "@tr", "@sp", "@st", "@sk", "@ra", "@ps"} {
+		delete(all, field)
+	}
+	if len(all) > 0 {
+		evt.Properties = all
+	}
+	return evt
+}
+
+func TestAppendString_MatchesEncodingJSON(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"plain",
+		`quotes " and \ backslashes`,
+		"control\n\r\t\x00\x1f",
+		"unicode: åäö 日本 🎉",
+		"invalid \xff utf-8",
+		"separators \u2028 \u2029",
+	} {
+		expected, err := json.Marshal(s)
+		require.NoError(t, err)
+		// encoding/json also escapes <, > and &, which we don't need to
+		assert.Equal(t, string(expected), string(appendString(nil, s)), "string %q", s)
+	}
+}
+
+func TestPropTree(t *testing.T) {
+	props := getPropTree()
+	defer putPropTree(props)
+
+	props.addAttr(rootNode, slog.String("http.method", "GET"), true)
+	props.addAttr(rootNode, slog.Int("http.status", 200), true)
+	props.addAttr(rootNode, slog.Group("user", slog.Int("id", 1), slog.String("name.first", "Ann")), true)
+	props.addAttr(rootNode, slog.Group("", slog.Bool("inlined", true)), true)
+	props.addAttr(rootNode, slog.String("user.role", "admin"), true)
+	props.addAttr(rootNode, slog.Int("http.status", 404), true) // replaces the value, keeps its place
+	props.addAttr(props.path(rootNode, "req.meta"), slog.String("id", "abc"), true)
+
+	line := (&SeqHandler{}).appendEvent(nil, &CLEFEvent{}, props)
+	_, after, _ := strings.Cut(string(line), `"@l":"",`)
+	assert.Equal(t, `"@m":"",`+
+		`"http":{"method":"GET","status":404},`+
+		`"user":{"id":1,"name.first":"Ann","role":"admin"},`+
+		`"inlined":true,`+
+		`"req":{"meta":{"id":"abc"}}}`+"\n", after)
+
+	v, ok := props.lookup("inlined")
+	assert.True(t, ok)
+	assert.Equal(t, true, v)
+	_, ok = props.lookup("http")
+	assert.False(t, ok, "groups aren't values")
+}
+
+func TestEncodeEntry_MatchesCLEF(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := CLEFEvent{
+		Timestamp:       ts,
+		Level:           "Warning",
+		MessageTemplate: "User {UserId} logged in",
+		EventType:       "deadbeef",
+		Renderings:      []string{"42"},
+		Exception:       "boom\n  at somewhere",
+		TraceID:         "0102",
+		SpanID:          "03",
+		Properties:      map[string]any{"UserId": 42},
+	}
+	e := testEntries(t, event)[0]
+
+	assert.True(t, strings.HasSuffix(string(e.line), "}\n"))
+	assert.Equal(t, ts, e.timestamp)
+	decoded := decodeEntry(t, e)
+	assert.Equal(t, map[string]any{"UserId": float64(42)}, decoded.Properties)
+	decoded.Properties = event.Properties
+	assert.Equal(t, event, decoded)
+}
+
+func TestHandle_EncodesOnce(t *testing.T) {
+	handler := newQueueingHandler()
+	defer handler.Close()
+
+	logger := slog.New(handler).With("service", "api").WithGroup("req")
+	logger.Info("handled", "method", "GET", slog.Group("user", "id", 7))
+
+	e := <-handler.workers[0].eventsCh
+	_, props, _ := strings.Cut(string(e.line), `"@m":"handled",`)
+	assert.Equal(t, `"service":"api","req":{"method":"GET","user":{"id":7}}}`+"\n", props)
+}
+
+func TestHandle_Allocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	handler := newQueueingHandler(WithQueueSize(1))
+	defer handler.Close()
+	logger := slog.New(handler).With("service", "api")
+	ctx := context.Background()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		logger.LogAttrs(ctx, slog.LevelInfo, "Handled request",
+			slog.String("method", "GET"), slog.String("path", "/api/items"), slog.Int("status", 200), slog.Float64("elapsed", 1.5))
+		<-handler.workers[0].eventsCh
+	})
+	// the copy of the encoded line is the only allocation the handler needs
+	assert.LessOrEqual(t, allocs, 1.0)
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// goldenLines logs a fixed set of events and returns their encoded lines.
+func goldenLines(t *testing.T) []byte {
+	handler := newQueueingHandler(
+		WithGlobalAttrs(slog.String("service", "api"), slog.String("env", "test")),
+		WithMessageTemplates(),
+	)
+	defer handler.Close()
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	var h slog.Handler = handler.WithAttrs([]slog.Attr{slog.String("zone", "eu"), slog.String("app.version", "1.2")})
+	h = h.WithGroup("req").WithAttrs([]slog.Attr{slog.String("id", "r1")})
+	r := slog.NewRecord(ts, slog.LevelInfo, "Handled {method}", 0)
+	r.AddAttrs(
+		slog.String("method", "GET"),
+		slog.Float64("elapsed", 12.34),
+		slog.Group("user", slog.Int("id", 7), slog.String("name", "ann")),
+		slog.String("http.status", "200"),
+		slog.Any("tags", map[string]any{"b": 2, "a": 1, "c": []any{"x"}}),
+	)
+	require.NoError(t, h.Handle(context.Background(), r))
+
+	handler.HandleCLEFEvent(CLEFEvent{
+		Timestamp: ts,
+		Level:     "Information",
+		Message:   "direct",
+		Properties: map[string]any{
+			"zebra": 1, "apple": 2, "@t": "escaped", "mango": map[string]any{"z": 1, "a": 2},
+		},
+	})
+
+	var lines []byte
+	for range 2 {
+		lines = append(lines, (<-handler.workers[0].eventsCh).line...)
+	}
+	return lines
+}
+
+// TestEncode_Golden checks that encoded events are byte for byte stable: CLEF
+// fields first, then properties in the order they were added.
+func TestEncode_Golden(t *testing.T) {
+	path := filepath.Join("testdata", "ordering.golden")
+	got := goldenLines(t)
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll("testdata", 0o755))
+		require.NoError(t, os.WriteFile(path, got, 0o644))
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "run go test -update to create the golden file")
+	assert.Equal(t, string(want), string(got))
+
+	for range 20 {
+		require.True(t, bytes.Equal(got, goldenLines(t)), "encoding is not deterministic")
+	}
+}
+
+// legacyEncode encodes a record the way Handle did before events were
+// encoded at enqueue time: into a map, nested by dotted keys, copied into
+// another map and encoded with encoding/json. It is only used to compare
+// against in benchmarks.
+func legacyEncode(r slog.Record) ([]byte, error) {
+	props := make(map[string]any)
+	r.Attrs(func(a slog.Attr) bool {
+		props[a.Key] = a.Value.Any()
+		return true
+	})
+	nested := make(map[string]any, len(props))
+	for k, v := range props {
+		path := strings.Split(k, ".")
+		dst := nested
+		for _, p := range path[:len(path)-1] {
+			child, ok := dst[p].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				dst[p] = child
+			}
+			dst = child
+		}
+		dst[path[len(path)-1]] = v
+	}
+	topLevel := map[string]any{
+		"@t": r.Time.Format(time.RFC3339Nano),
+		"@l": "Information",
+		"@m": r.Message,
+	}
+	maps.Copy(topLevel, nested)
+	line, err := json.Marshal(topLevel)
+	return append(line, '\n'), err
+}
+
+func benchmarkRecord() slog.Record {
+	r := slog.NewRecord(time.Now(), slog.LevelInfo, "Handled request", 0)
+	r.AddAttrs(
+		slog.String("http.method", "GET"),
+		slog.String("http.path", "/api/items/42"),
+		slog.Int("http.status", 200),
+		slog.Float64("elapsed", 12.5),
+		slog.String("service", "api"),
+		slog.Bool("cached", false),
+	)
+	return r
+}
+
+// BenchmarkEncode compares allocations per event of encoding a record at
+// enqueue time with the map based encoding it replaced.
+func BenchmarkEncode(b *testing.B) {
+	r := benchmarkRecord()
+
+	b.Run("legacy", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := legacyEncode(r); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("handler", func(b *testing.B) {
+		handler := newQueueingHandler(WithQueueSize(1))
+		defer handler.Close()
+		ctx := context.Background()
+
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := handler.Handle(ctx, r); err != nil {
+				b.Fatal(err)
+			}
+			<-handler.workers[0].eventsCh
+		}
+	})
+}
@@ -1,12 +1,17 @@
 package slogseq
 
-import "time"
+import (
+	"time"
+)
 
 // Compact Log Event Format (CLEF) is a JSON-based log event format that Seq uses.
 // https://clef-json.org
 type CLEFEvent struct {
 	Timestamp          time.Time      `json:"@t,omitzero"`
 	Message            string         `json:"@m,omitempty"`
+	MessageTemplate    string         `json:"@mt,omitempty"`
+	Renderings         []string       `json:"@r,omitempty"`
+	EventType          string         `json:"@i,omitempty"`
 	Exception          string         `json:"@x,omitempty"`
 	Level              string         `json:"@l"`
 	Properties         map[string]any `json:"-"`
@@ -32,3 +37,18 @@ const (
 func (l CLEFLevel) String() string {
 	return string(l)
 }
+
+// ReservedKeyPolicy decides what happens to properties whose names start with
+// "@", which CLEF reserves for its own fields such as @t, @l and @m.
+type ReservedKeyPolicy int
+
+const (
+	// ReservedKeyEscape doubles the leading "@" of such properties, as the CLEF
+	// spec requires, so "@t" is sent as "@@t" and shows up in Seq as "@t". This is the default.
+	ReservedKeyEscape ReservedKeyPolicy = iota
+	// ReservedKeyDrop drops such properties.
+	ReservedKeyDrop
+	// ReservedKeyOverwrite sends such properties as they are, so they can
+	// overwrite the CLEF fields of the event.
+	ReservedKeyOverwrite
+)
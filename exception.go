@@ -0,0 +1,142 @@
+package slogseq
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// defaultErrorKeys are the attribute keys whose error values are rendered into @x.
+var defaultErrorKeys = []string{"error", "err"}
+
+// maxErrorDepth bounds how many errors deep, through wraps and branches
+// alike, an error tree is rendered, and maxErrors how many errors it renders
+// in all, in case an error unwraps to itself.
+const (
+	maxErrorDepth = 32
+	maxErrors     = 256
+)
+
+// renderException renders an error as the text of a CLEF exception (@x): the
+// error and everything it wraps, one per line with its type, followed by the
+// stack trace of every error that carries one, either as program counters or
+// as text from a Stack() []byte method. Branches of errors.Join and
+// other multi-errors are rendered indented under their parent.
+func renderException(err error) string {
+	w := exceptionWriter{parents: make(map[error]bool)}
+	w.write(err, 0, 0)
+	return strings.TrimSuffix(w.b.String(), "\n")
+}
+
+type exceptionWriter struct {
+	b strings.Builder
+	// the errors on the way down to the one being written
+	parents map[error]bool
+	written int // errors written, including "..."
+}
+
+// write writes err indented by level, steps errors down the tree. An error
+// that contains itself is only expanded once.
+func (w *exceptionWriter) write(err error, level, steps int) {
+	b := &w.b
+	indent := strings.Repeat("  ", level)
+	if w.written >= maxErrors {
+		return
+	}
+	w.written++
+	if steps >= maxErrorDepth || w.written == maxErrors {
+		b.WriteString(indent + "...\n")
+		return
+	}
+	if reflect.TypeOf(err).Kind() == reflect.Pointer {
+		// only pointers can be compared safely, and only they can refer to themselves
+		if w.parents[err] {
+			fmt.Fprintf(b, "%s%T: (cycle)\n", indent, err)
+			return
+		}
+		w.parents[err] = true
+		defer delete(w.parents, err)
+	}
+
+	fmt.Fprintf(b, "%s%T: %s\n", indent, err, indentLines(err.Error(), indent+"  "))
+	for _, frame := range errorStack(err) {
+		fmt.Fprintf(b, "%s   at %s in %s:%d\n", indent, frame.Function, frame.File, frame.Line)
+	}
+	if s, ok := err.(interface{ Stack() []byte }); ok {
+		// already formatted, as returned by runtime/debug.Stack
+		b.Write(s.Stack())
+		if !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	switch u := err.(type) {
+	case interface{ Unwrap() error }:
+		if next := u.Unwrap(); next != nil {
+			// a chain of wraps stays at the same level, like inner exceptions
+			w.write(next, level, steps+1)
+		}
+	case interface{ Unwrap() []error }:
+		for _, next := range u.Unwrap() {
+			if next != nil {
+				w.write(next, level+1, steps+1)
+			}
+		}
+	}
+}
+
+// indentLines indents all but the first line of s, so multi-line error
+// messages don't break up the tree.
+func indentLines(s, indent string) string {
+	return strings.ReplaceAll(s, "\n", "\n"+indent)
+}
+
+// errorStack returns the stack trace an error carries, if any. It understands
+// the StackTrace method of github.com/pkg/errors and similar packages, and a
+// Callers method returning the result of runtime.Callers, without depending
+// on any of them.
+func errorStack(err error) []runtime.Frame {
+	v := reflect.ValueOf(err)
+	for _, name := range []string{"StackTrace", "Callers"} {
+		m := v.MethodByName(name)
+		if !m.IsValid() || m.Type().NumIn() != 0 || m.Type().NumOut() != 1 {
+			continue
+		}
+		if pcs, ok := programCounters(m.Type().Out(0)); ok {
+			return callersFrames(pcs(m.Call(nil)[0]))
+		}
+	}
+	return nil
+}
+
+// programCounters reports whether t is a slice of program counters, such as
+// []uintptr or errors.StackTrace, and returns a function to extract them.
+func programCounters(t reflect.Type) (func(reflect.Value) []uintptr, bool) {
+	if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Uintptr {
+		return nil, false
+	}
+	return func(v reflect.Value) []uintptr {
+		pcs := make([]uintptr, v.Len())
+		for i := range pcs {
+			pcs[i] = uintptr(v.Index(i).Uint())
+		}
+		return pcs
+	}, true
+}
+
+func callersFrames(pcs []uintptr) []runtime.Frame {
+	if len(pcs) == 0 {
+		return nil
+	}
+	var out []runtime.Frame
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		out = append(out, frame)
+		if !more {
+			break
+		}
+	}
+	return out
+}
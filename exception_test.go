@@ -0,0 +1,153 @@
+package slogseq
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// frame and stackTrace mirror the types of github.com/pkg/errors.
+type frame uintptr
+type stackTrace []frame
+
+type pkgError struct {
+	msg   string
+	stack []uintptr
+}
+
+func (e *pkgError) Error() string { return e.msg }
+
+func (e *pkgError) StackTrace() stackTrace {
+	st := make(stackTrace, len(e.stack))
+	for i, pc := range e.stack {
+		st[i] = frame(pc)
+	}
+	return st
+}
+
+type callersError struct {
+	err     error
+	callers []uintptr
+}
+
+func (e *callersError) Error() string      { return e.err.Error() }
+func (e *callersError) Unwrap() error      { return e.err }
+func (e *callersError) Callers() []uintptr { return e.callers }
+
+func captureCallers() []uintptr {
+	pcs := make([]uintptr, 32)
+	return pcs[:runtime.Callers(2, pcs)]
+}
+
+func TestRenderException_WrapChain(t *testing.T) {
+	inner := &fs.PathError{Op: "open", Path: "config.json", Err: fs.ErrNotExist}
+	err := fmt.Errorf("loading config: %w", inner)
+
+	expected := "*fmt.wrapError: loading config: open config.json: file does not exist\n" +
+		"*fs.PathError: open config.json: file does not exist\n" +
+		"*errors.errorString: file does not exist"
+	assert.Equal(t, expected, renderException(err))
+}
+
+func TestRenderException_Join(t *testing.T) {
+	err := fmt.Errorf("cleanup: %w", errors.Join(errors.New("first"), fmt.Errorf("second: %w", errors.New("cause"))))
+
+	expected := "*fmt.wrapError: cleanup: first\n" +
+		"  second: cause\n" +
+		"*errors.joinError: first\n" +
+		"  second: cause\n" +
+		"  *errors.errorString: first\n" +
+		"  *fmt.wrapError: second: cause\n" +
+		"  *errors.errorString: cause"
+	assert.Equal(t, expected, renderException(err))
+}
+
+func TestRenderException_StackTraces(t *testing.T) {
+	err := &pkgError{msg: "with stack", stack: captureCallers()}
+	rendered := renderException(err)
+	lines := strings.Split(rendered, "\n")
+	require.Greater(t, len(lines), 1)
+	assert.Equal(t, "*slogseq.pkgError: with stack", lines[0])
+	assert.Contains(t, lines[1], "   at github.com/sokkalf/slog-seq.TestRenderException_StackTraces in ")
+	assert.Contains(t, lines[1], "exception_test.go:")
+
+	err2 := &callersError{err: errors.New("cause"), callers: captureCallers()}
+	rendered = renderException(err2)
+	assert.Contains(t, rendered, "   at github.com/sokkalf/slog-seq.TestRenderException_StackTraces in ")
+	assert.True(t, strings.HasSuffix(rendered, "\n*errors.errorString: cause"))
+}
+
+func TestHandle_ErrorAttributes(t *testing.T) {
+	handler := newQueueingHandler()
+	defer handler.Close()
+	logger := slog.New(handler)
+
+	err := fmt.Errorf("saving: %w", errors.New("disk full"))
+	logger.WithGroup("req").Error("request failed\nwhile saving", "err", err, "other", errors.New("not rendered"))
+	evt := decodeEntry(t, <-handler.workers[0].priorityCh)
+
+	assert.Equal(t, "request failed", evt.Message)
+	assert.Equal(t, "while saving\n*fmt.wrapError: saving: disk full\n*errors.errorString: disk full", evt.Exception)
+	req := evt.Properties["req"].(map[string]any)
+	assert.Equal(t, "saving: disk full", req["err"], "the attribute keeps the message")
+	assert.Equal(t, "not rendered", req["other"])
+}
+
+func TestWithErrorKeys(t *testing.T) {
+	handler := newQueueingHandler(WithErrorKeys("cause"))
+	defer handler.Close()
+	logger := slog.New(handler)
+
+	logger.Error("first", "err", errors.New("ignored"))
+	logger.Error("second", slog.Any("cause", errors.New("rendered")))
+
+	assert.Empty(t, decodeEntry(t, <-handler.workers[0].priorityCh).Exception)
+	assert.Equal(t, "*errors.errorString: rendered", decodeEntry(t, <-handler.workers[0].priorityCh).Exception)
+}
+
+// loopError unwraps to itself.
+type loopError struct{}
+
+func (e *loopError) Error() string { return "loop" }
+func (e *loopError) Unwrap() error { return e }
+
+// chainError unwraps to a new chainError, forever.
+type chainError struct{}
+
+func (chainError) Error() string { return "chain" }
+func (chainError) Unwrap() error { return chainError{} }
+
+// forkError branches into two new forkErrors, forever.
+type forkError struct{}
+
+func (forkError) Error() string   { return "fork" }
+func (forkError) Unwrap() []error { return []error{forkError{}, forkError{}} }
+
+// selfJoinError holds itself twice, like an errors.Join containing itself.
+type selfJoinError struct{}
+
+func (e *selfJoinError) Error() string   { return "join" }
+func (e *selfJoinError) Unwrap() []error { return []error{e, e} }
+
+func TestRenderException_EndlessErrors(t *testing.T) {
+	assert.Equal(t, "*slogseq.loopError: loop\n*slogseq.loopError: (cycle)", renderException(&loopError{}))
+
+	chain := strings.Split(renderException(chainError{}), "\n")
+	assert.Len(t, chain, maxErrorDepth+1)
+	assert.Equal(t, "...", chain[maxErrorDepth])
+
+	assert.Equal(t, "*slogseq.selfJoinError: join\n"+
+		"  *slogseq.selfJoinError: (cycle)\n"+
+		"  *slogseq.selfJoinError: (cycle)", renderException(&selfJoinError{}))
+
+	fork := strings.Split(renderException(forkError{}), "\n")
+	assert.Len(t, fork, maxErrors)
+	assert.Equal(t, "...", strings.TrimSpace(fork[len(fork)-1]))
+}
@@ -1,13 +1,15 @@
 package slogseq
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
-	"encoding/json"
-	"maps"
+	"errors"
+	"log/slog"
 	"net"
 	"net/http"
-	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 func (h *SeqHandler) runBackgroundFlusher(w *worker) {
@@ -23,32 +25,45 @@ func (h *SeqHandler) runBackgroundFlusher(w *worker) {
 	w.purgeTicker = time.NewTicker(purgeInterval)
 	defer w.purgeTicker.Stop()
 
-	events := make([]CLEFEvent, 0, h.batchSize)
+	ctx := context.Background()
+
+	// replay batches spooled before a restart
+	h.drainSpool(ctx, w)
+
+	events := make([]entry, 0, h.batchSize)
 
 	for {
+		w.stats.retryBufferSize.Store(int64(len(w.retryBuffer)))
+		w.hold(events)
+
 		select {
 		case e, ok := <-w.eventsCh:
 			if !ok {
-				if len(events) > 0 {
-					if len(w.retryBuffer) > 0 {
-						leftover := h.sendWithRetry(w.retryBuffer)
-						w.retryBuffer = leftover
-					}
-					leftover := h.sendWithRetry(events)
-					if leftover != nil {
-						w.retryBuffer = append(w.retryBuffer, leftover...)
-					}
-				}
+				w.result = h.flushAll(ctx, w, &events)
 				return
 			}
 			events = append(events, e)
 			if len(events) >= h.batchSize {
-				h.flushCurrentBatch(w, &events)
+				h.flushCurrentBatch(ctx, w, &events)
+			}
+
+		case e := <-w.priorityCh:
+			// don't wait for the ticker, take whatever else is urgent along
+			events = append(events, e)
+			for queued := len(w.priorityCh); queued > 0; queued-- {
+				events = append(events, <-w.priorityCh)
 			}
+			h.flushCurrentBatch(ctx, w, &events)
 
 		case <-ticker.C:
-			if len(events) > 0 {
-				h.flushCurrentBatch(w, &events)
+			if len(events) > 0 || len(w.retryBuffer) > 0 {
+				h.flushCurrentBatch(ctx, w, &events)
+			} else if h.spool != nil && h.spool.len() > 0 {
+				// nothing new to send, but Seq might be back for what was spooled
+				h.drainSpool(ctx, w)
+			} else if h.state.levelCheckDue() {
+				// nothing to send, but Seq might have lowered the minimum level
+				h.postBatch(ctx, w, nil)
 			}
 
 		case <-w.purgeTicker.C:
@@ -56,115 +71,366 @@ func (h *SeqHandler) runBackgroundFlusher(w *worker) {
 			cutoff := time.Now().Add(-5 * time.Minute)
 			h.purgeOldEvents(w, cutoff)
 
+		case req := <-w.flushCh:
+			req.done <- h.flushAll(req.ctx, w, &events)
+
 		case <-w.doneCh:
-			if len(events) > 0 {
-				h.flushCurrentBatch(w, &events)
-			}
+			w.result = h.flushAll(h.state.shutdownContext(), w, &events)
 			return
 		}
 	}
 }
 
-func (h *SeqHandler) flushCurrentBatch(w *worker, events *[]CLEFEvent) {
+// flushAll sends everything the worker holds: the events queued in its
+// channel, the current batch and the retry buffer.
+func (h *SeqHandler) flushAll(ctx context.Context, w *worker, events *[]entry) flushResult {
+	// flushing on request, don't wait for the backoff to expire
+	w.nextAttempt = time.Time{}
+
+	// Only take what is queued right now, so a steady stream of new events
+	// can't keep the flush going forever.
+	for queued := len(w.priorityCh); queued > 0; queued-- {
+		*events = append(*events, <-w.priorityCh)
+		w.hold(*events)
+	}
+	for queued := len(w.eventsCh); queued > 0; queued-- {
+		e, ok := <-w.eventsCh
+		if !ok {
+			break
+		}
+		*events = append(*events, e)
+		w.hold(*events)
+		if len(*events) >= h.batchSize {
+			h.flushCurrentBatch(ctx, w, events)
+		}
+	}
+	h.flushCurrentBatch(ctx, w, events)
+
+	res := flushResult{undelivered: len(w.retryBuffer)}
+	if res.undelivered > 0 {
+		res.err = w.lastErr
+	}
+	if w.nextAttempt.IsZero() {
+		// Seq took this worker's events, so try what was spooled earlier too
+		if err := h.flushSpool(ctx, w); err != nil {
+			res.err = err
+		}
+	} else if h.spool != nil {
+		res.err = w.lastErr
+	}
+	return res
+}
+
+func (h *SeqHandler) flushCurrentBatch(ctx context.Context, w *worker, events *[]entry) {
+	// whatever doesn't end up in the retry buffer no longer takes up memory
+	w.hold(*events)
+	held := h.memory.sizeOf(*events) + h.memory.sizeOf(w.retryBuffer)
+	defer func() {
+		*events = (*events)[:0]
+		w.hold(nil)
+		h.memory.release(held - h.memory.sizeOf(w.retryBuffer))
+	}()
+
+	if time.Now().Before(w.nextAttempt) {
+		// backing off after a failure, keep the batch until the next attempt
+		h.keepForRetry(w, *events)
+		return
+	}
+
 	if len(w.retryBuffer) > 0 {
-		leftover := h.sendWithRetry(w.retryBuffer)
+		leftover := h.sendWithRetry(ctx, w, w.retryBuffer)
 		w.retryBuffer = leftover
+		if leftover != nil {
+			// Seq is still unavailable, no point in sending more right now
+			h.keepForRetry(w, *events)
+			return
+		}
 	}
-	leftover := h.sendWithRetry(*events)
+	leftover := h.sendWithRetry(ctx, w, *events)
 
 	if leftover != nil {
 		w.retryBuffer = append(w.retryBuffer, leftover...)
 	}
-	*events = (*events)[:0]
 }
 
-func encodeEvent(e CLEFEvent) map[string]any {
-	topLevel := map[string]any{
-		"@t": e.Timestamp.Format(time.RFC3339Nano),
-		"@m": e.Message,
-		"@l": e.Level,
+// truncatedKey is the property added to events that were cut down to fit
+// the maximum event size. It holds the size of the original event.
+const truncatedKey = "_truncated"
+
+// truncateEvent replaces an oversized event by one that only keeps its
+// well-known fields and as much of the message and exception as fits.
+func (h *SeqHandler) truncateEvent(e *CLEFEvent, size, maxBytes int) (entry, error) {
+	t := CLEFEvent{
+		Timestamp:    e.Timestamp,
+		Level:        e.Level,
+		EventType:    e.EventType,
+		TraceID:      e.TraceID,
+		SpanID:       e.SpanID,
+		SpanStart:    e.SpanStart,
+		SpanKind:     e.SpanKind,
+		ParentSpanID: e.ParentSpanID,
 	}
-	if e.Exception != "" {
-		topLevel["@x"] = e.Exception
+	props := getPropTree()
+	defer putPropTree(props)
+	props.set(rootNode, truncatedKey, slog.IntValue(size))
+
+	message, exception := e.Message, e.Exception
+	if message == "" {
+		// without the properties the template can't be rendered, so send it as the message
+		message = e.MessageTemplate
 	}
-	if !e.SpanStart.IsZero() {
-		topLevel["@st"] = e.SpanStart.Format(time.RFC3339Nano)
+	for {
+		t.Message, t.Exception = message, exception
+		line := h.appendEvent(nil, &t, props)
+		if len(line) <= maxBytes {
+			return h.newEntry(line, &t, props), nil
+		}
+		if message == "" && exception == "" {
+			return entry{}, errEventTooLarge
+		}
+		excess := len(line) - maxBytes
+		if exception != "" {
+			exception = truncateString(exception, len(exception)-excess)
+		} else {
+			message = truncateString(message, len(message)-excess)
+		}
 	}
-	if e.TraceID != "" {
-		topLevel["@tr"] = e.TraceID
+}
+
+// truncateString cuts s to at most n bytes without splitting a UTF-8 sequence.
+func truncateString(s string, n int) string {
+	if n <= 0 {
+		return ""
 	}
-	if e.SpanID != "" {
-		topLevel["@sp"] = e.SpanID
+	if len(s) <= n {
+		return s
 	}
-	if e.ParentSpanID != "" {
-		topLevel["@ps"] = e.ParentSpanID
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	if len(e.ResourceAttributes) > 0 {
-		topLevel["@ra"] = e.ResourceAttributes
+	return s[:n]
+}
+
+func (h *SeqHandler) attemptSendBatch(ctx context.Context, w *worker, events []entry) ([]entry, error) {
+	if len(events) == 0 {
+		return nil, nil
 	}
-	if e.SpanKind != "" {
-		topLevel["@sk"] = e.SpanKind
+
+	n, err := h.postEntries(ctx, w, events)
+	if err != nil {
+		return events[n:], err
 	}
-	maps.Copy(topLevel, e.Properties)
-	return topLevel
+
+	// Seq is reachable again, replay anything spooled during the outage
+	h.drainSpool(ctx, w)
+	return nil, nil
 }
 
-func (h *SeqHandler) attemptSendBatch(events []CLEFEvent) bool {
-	if len(events) == 0 {
-		return true
+// entryLines returns the CLEF lines of entries.
+func entryLines(entries []entry) [][]byte {
+	lines := make([][]byte, len(entries))
+	for i, e := range entries {
+		lines[i] = e.line
 	}
+	return lines
+}
 
-	var sb strings.Builder
-	enc := json.NewEncoder(&sb)
-	for _, e := range events {
-		topLevel := encodeEvent(e)
-		if err := enc.Encode(topLevel); err != nil {
-			// Return false => indicates we should retry
-			return false
+// postEntries sends entries to Seq in as many requests as maxBatchBytes
+// requires. With OTLP, spans and log events go to different endpoints, so they
+// are never sent together. It returns how many entries, from the start, were
+// dealt with.
+func (h *SeqHandler) postEntries(ctx context.Context, w *worker, entries []entry) (int, error) {
+	done := 0
+	for done < len(entries) {
+		end := done + 1
+		size := len(entries[done].line)
+		for end < len(entries) && (h.maxBatchBytes <= 0 || size+len(entries[end].line) <= h.maxBatchBytes) &&
+			(h.protocol != ProtocolOTLP || entries[end].span == entries[done].span) {
+			size += len(entries[end].line)
+			end++
+		}
+		n, err := h.postChunk(ctx, w, entries[done:end])
+		done += n
+		if err != nil {
+			return done, err
 		}
 	}
+	return done, nil
+}
 
-	req, err := http.NewRequest("POST", h.seqURL, strings.NewReader(sb.String()))
+// postChunk sends entries in a single request. If Seq finds the request too
+// large, the chunk is split in half until it fits; a single event that is
+// still too large is dropped.
+func (h *SeqHandler) postChunk(ctx context.Context, w *worker, entries []entry) (int, error) {
+	err := h.postBatch(ctx, w, entries)
+	if err == nil {
+		return len(entries), nil
+	}
+	var se *sendError
+	if !errors.As(err, &se) || se.statusCode != http.StatusRequestEntityTooLarge {
+		return 0, err
+	}
+	if len(entries) == 1 {
+		h.recordDropped(w, dropUndeliverable, 1)
+		return 1, nil
+	}
+
+	mid := len(entries) / 2
+	n, err := h.postChunk(ctx, w, entries[:mid])
 	if err != nil {
-		return false
+		return n, err
+	}
+	n, err = h.postChunk(ctx, w, entries[mid:])
+	return mid + n, err
+}
+
+// postBatch sends entries to Seq in a single request, as CLEF lines. With OTLP
+// they are sent as an export request instead.
+func (h *SeqHandler) postBatch(ctx context.Context, w *worker, entries []entry) error {
+	url, contentType := h.seqURL, "application/vnd.serilog.clef"
+	var payload [][]byte
+	if h.protocol == ProtocolOTLP {
+		if len(entries) == 0 {
+			return nil // a level check, which the OTLP endpoint doesn't answer
+		}
+		otlpURL, otlpBody, err := h.otlpBody(entries)
+		if err != nil {
+			return &sendError{permanent: true, err: err}
+		}
+		url, contentType, payload = otlpURL, "application/x-protobuf", [][]byte{otlpBody}
+	} else {
+		payload = entryLines(entries)
+	}
+
+	var body bytes.Buffer
+	encoding, err := h.writeBody(&body, payload)
+	if err != nil {
+		return &sendError{permanent: true, err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &body)
+	if err != nil {
+		return &sendError{permanent: true, err: err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
 	}
-	req.Header.Set("Content-Type", "application/vnd.serilog.clef")
 	if h.apiKey != "" {
 		req.Header.Set("X-Seq-ApiKey", h.apiKey)
 	}
 
+	bodyBytes := body.Len()
+	start := time.Now()
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return false
+		h.metrics.recordRequest(ctx, len(entries), bodyBytes, 0, time.Since(start))
+		return h.requestFailed(w, &sendError{err: err})
 	}
 	defer resp.Body.Close()
+	h.metrics.recordRequest(ctx, len(entries), bodyBytes, resp.StatusCode, time.Since(start))
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return false
+		return h.requestFailed(w, &sendError{
+			statusCode: resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		})
+	}
+	if h.protocol == ProtocolCLEF {
+		h.state.updateMinimumLevelAccepted(resp.Body)
 	}
 
 	// Success
-	return true
+	w.recordSent(len(entries))
+	return nil
+}
+
+// requestFailed records a failed request and reports it to the error handler.
+func (h *SeqHandler) requestFailed(w *worker, err error) error {
+	w.recordFailedRequest(err)
+	h.reportError(err)
+	return err
+}
+
+// reportError passes err to the error handler, if there is one.
+func (h *SeqHandler) reportError(err error) {
+	if h.errorHandler != nil {
+		h.errorHandler(err)
+	}
 }
 
-func (h *SeqHandler) sendWithRetry(events []CLEFEvent) []CLEFEvent {
+// sendWithRetry sends events and returns the ones that should be retried later.
+// Events that failed permanently, or more often than the retry policy allows,
+// are dropped.
+func (h *SeqHandler) sendWithRetry(ctx context.Context, w *worker, events []entry) []entry {
 	if len(events) == 0 {
 		return nil
 	}
-	success := h.attemptSendBatch(events)
-	if success {
+	events, err := h.attemptSendBatch(ctx, w, events)
+	if err == nil {
+		w.failures = 0
+		w.nextAttempt = time.Time{}
+		w.lastErr = nil
 		return nil // nothing left to retry
 	}
+	w.lastErr = err
+
+	if !h.retryPolicy.retryable(err) {
+		// e.g. a malformed payload, Seq will never accept it
+		h.recordDropped(w, dropUndeliverable, len(events))
+		return nil
+	}
+
+	w.failures++
+	var retryAfter time.Duration
+	if se, ok := err.(*sendError); ok {
+		retryAfter = se.retryAfter
+	}
+	w.nextAttempt = time.Now().Add(h.retryPolicy.delay(w.failures, retryAfter))
+
+	if h.spoolEvents(w, events) {
+		return nil // safely on disk, replayed once Seq is back
+	}
+	if h.retryPolicy.MaxAttempts > 0 && w.failures >= h.retryPolicy.MaxAttempts {
+		w.failures = 0
+		h.recordDropped(w, dropUndeliverable, len(events))
+		return nil // given up
+	}
 	return events
 }
 
+// keepForRetry holds on to events that are not sent right now.
+func (h *SeqHandler) keepForRetry(w *worker, events []entry) {
+	if len(events) == 0 || h.spoolEvents(w, events) {
+		return
+	}
+	w.retryBuffer = append(w.retryBuffer, events...)
+}
+
+// spoolEvents writes events to the spool, if one is configured.
+func (h *SeqHandler) spoolEvents(w *worker, events []entry) bool {
+	if h.spool == nil {
+		return false
+	}
+	evicted, err := h.spool.write(bytes.Join(entryLines(events), nil))
+	h.recordDropped(w, dropSpoolFull, evicted)
+	if err != nil {
+		return false
+	}
+	w.recordSpooled(len(events))
+	return true
+}
+
 func (h *SeqHandler) purgeOldEvents(w *worker, olderThan time.Time) {
+	held := h.memory.sizeOf(w.retryBuffer)
 	newBuf := w.retryBuffer[:0]
 	for _, e := range w.retryBuffer {
-		if e.Timestamp.After(olderThan) {
+		if e.timestamp.After(olderThan) {
 			newBuf = append(newBuf, e)
 		}
 	}
+	h.recordDropped(w, dropPurged, len(w.retryBuffer)-len(newBuf))
+	h.memory.release(held - h.memory.sizeOf(newBuf))
 	w.retryBuffer = newBuf
 }
 
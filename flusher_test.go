@@ -2,8 +2,11 @@ package slogseq
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -22,15 +25,103 @@ func (m *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 
 func GetHttpClientMock(status int, msg string, f func()) *http.Client {
 	f()
-	transport := &mockTransport{
-		RoundTripFunc: func(req *http.Request) (*http.Response, error) {
-			return &http.Response{
-				StatusCode: status,
-				Body:       io.NopCloser(bytes.NewBufferString(msg)),
-			}, nil
-		},
+	seq := newFakeSeq(status)
+	seq.response = msg
+	return seq.client()
+}
+
+// fakeSeq stands in for Seq in tests. It answers every request with status,
+// or with what respond returns if it is set, and records the requests. It is
+// safe to use from several workers at once; set respond, header and response
+// before using it.
+type fakeSeq struct {
+	respond  func(req *http.Request, body []byte) (int, error)
+	header   http.Header // of every response
+	response string      // body of every response
+
+	mu       sync.Mutex
+	status   int
+	requests []fakeRequest
+}
+
+// fakeRequest is a request received by fakeSeq.
+type fakeRequest struct {
+	header http.Header
+	path   string
+	body   []byte // as sent, compressed or not
+	status int
+}
+
+func newFakeSeq(status int) *fakeSeq {
+	return &fakeSeq{status: status}
+}
+
+func (f *fakeSeq) client() *http.Client {
+	return &http.Client{Transport: &mockTransport{RoundTripFunc: f.roundTrip}}
+}
+
+func (f *fakeSeq) roundTrip(req *http.Request) (*http.Response, error) {
+	body, _ := io.ReadAll(req.Body)
+	f.mu.Lock()
+	status := f.status
+	f.mu.Unlock()
+	if f.respond != nil {
+		var err error
+		if status, err = f.respond(req, body); err != nil {
+			return nil, err
+		}
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{header: req.Header, path: req.URL.Path, body: body, status: status})
+	f.mu.Unlock()
+	return &http.Response{StatusCode: status, Header: f.header, Body: io.NopCloser(strings.NewReader(f.response))}, nil
+}
+
+// block makes requests hang until they are canceled.
+func (f *fakeSeq) block() *fakeSeq {
+	f.respond = func(req *http.Request, body []byte) (int, error) {
+		<-req.Context().Done()
+		return 0, req.Context().Err()
 	}
-	return &http.Client{Transport: transport}
+	return f
+}
+
+func (f *fakeSeq) setStatus(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status = status
+}
+
+// received returns the requests received so far.
+func (f *fakeSeq) received() []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.requests)
+}
+
+func (f *fakeSeq) calls() int {
+	return len(f.received())
+}
+
+// bodies returns the bodies of the requests that were accepted.
+func (f *fakeSeq) bodies() []string {
+	var bodies []string
+	for _, r := range f.received() {
+		if r.status >= 200 && r.status <= 299 {
+			bodies = append(bodies, string(r.body))
+		}
+	}
+	return bodies
+}
+
+// bytesSent returns the size of all request bodies, as sent on the wire.
+func (f *fakeSeq) bytesSent() int64 {
+	var n int64
+	for _, r := range f.received() {
+		n += int64(len(r.body))
+	}
+	return n
 }
 
 func TestRunBackgroundFlusher_BasicFlushOnBatchSize(t *testing.T) {
@@ -42,10 +133,10 @@ func TestRunBackgroundFlusher_BasicFlushOnBatchSize(t *testing.T) {
 		batchSize:     2,               // flush after 2 events
 		workerCount:   1,
 		workers: []worker{{
-			eventsCh:    make(chan CLEFEvent, 10),
+			eventsCh:    make(chan entry, 10),
 			doneCh:      make(chan struct{}),
 			wg:          sync.WaitGroup{},
-			retryBuffer: make([]CLEFEvent, 0),
+			retryBuffer: make([]entry, 0),
 		}},
 	}
 
@@ -56,11 +147,13 @@ func TestRunBackgroundFlusher_BasicFlushOnBatchSize(t *testing.T) {
 	go handler.runBackgroundFlusher(w)
 
 	// Send 2 events (exactly batchSize); expect immediate flush
-	e1 := CLEFEvent{Message: "event1", Timestamp: time.Now()}
-	e2 := CLEFEvent{Message: "event2", Timestamp: time.Now()}
+	events := testEntries(t,
+		CLEFEvent{Message: "event1", Timestamp: time.Now()},
+		CLEFEvent{Message: "event2", Timestamp: time.Now()},
+	)
 
-	w.eventsCh <- e1
-	w.eventsCh <- e2
+	w.eventsCh <- events[0]
+	w.eventsCh <- events[1]
 
 	// Close eventsCh and signal doneCh, then wait
 	close(w.eventsCh)
@@ -94,7 +187,7 @@ func TestRunBackgroundFlusher_FlushOnInterval(t *testing.T) {
 	}
 
 	w := &worker{
-		eventsCh: make(chan CLEFEvent, 10),
+		eventsCh: make(chan entry, 10),
 		doneCh:   make(chan struct{}),
 		wg:       sync.WaitGroup{},
 	}
@@ -104,7 +197,7 @@ func TestRunBackgroundFlusher_FlushOnInterval(t *testing.T) {
 	go handler.runBackgroundFlusher(w)
 
 	// Send 1 event (less than batchSize).
-	w.eventsCh <- CLEFEvent{Message: "event1", Timestamp: time.Now()}
+	w.eventsCh <- testEntries(t, CLEFEvent{Message: "event1", Timestamp: time.Now()})[0]
 
 	// Wait a bit longer than flushInterval to ensure flush is triggered
 	time.Sleep(2 * flushInterval)
@@ -138,7 +231,7 @@ func TestRunBackgroundFlusher_RetryOnFailure(t *testing.T) {
 	}
 
 	w := &worker{
-		eventsCh:    make(chan CLEFEvent, 10),
+		eventsCh:    make(chan entry, 10),
 		doneCh:      make(chan struct{}),
 		wg:          sync.WaitGroup{},
 		retryBuffer: nil, // start empty
@@ -149,11 +242,13 @@ func TestRunBackgroundFlusher_RetryOnFailure(t *testing.T) {
 	go handler.runBackgroundFlusher(w)
 
 	// Send 2 events -> triggers flush immediately (batchSize=2).
-	e1 := CLEFEvent{Message: "fail1", Timestamp: time.Now()}
-	e2 := CLEFEvent{Message: "fail2", Timestamp: time.Now()}
+	events := testEntries(t,
+		CLEFEvent{Message: "fail1", Timestamp: time.Now()},
+		CLEFEvent{Message: "fail2", Timestamp: time.Now()},
+	)
 
-	w.eventsCh <- e1
-	w.eventsCh <- e2
+	w.eventsCh <- events[0]
+	w.eventsCh <- events[1]
 	handler.client = successClient // switch to success client for next batch
 
 	// Close and wait
@@ -180,7 +275,7 @@ func TestPurgeOldEvents(t *testing.T) {
 	newEvent := CLEFEvent{Message: "new", Timestamp: now.Add(-1 * time.Minute)}
 
 	handler := &SeqHandler{
-		workers: []worker{{retryBuffer: []CLEFEvent{oldEvent, newEvent}}},
+		workers: []worker{{retryBuffer: testEntries(t, oldEvent, newEvent)}},
 	}
 	w := &handler.workers[0]
 
@@ -189,7 +284,7 @@ func TestPurgeOldEvents(t *testing.T) {
 
 	// We expect only the new event to remain (the old one is older than cutoff).
 	require.Len(t, w.retryBuffer, 1, "expected only one event left in retryBuffer")
-	assert.Equal(t, "new", w.retryBuffer[0].Message)
+	assert.Equal(t, "new", decodeEntry(t, w.retryBuffer[0]).Message)
 }
 
 func TestNoFlushMode(t *testing.T) {
@@ -200,7 +295,7 @@ func TestNoFlushMode(t *testing.T) {
 	}
 
 	w := &worker{
-		eventsCh: make(chan CLEFEvent, 1),
+		eventsCh: make(chan entry, 1),
 		doneCh:   make(chan struct{}),
 		wg:       sync.WaitGroup{},
 	}
@@ -210,7 +305,7 @@ func TestNoFlushMode(t *testing.T) {
 	go handler.runBackgroundFlusher(w)
 
 	// Even if we send events, it should immediately return and do nothing.
-	w.eventsCh <- CLEFEvent{Message: "test", Timestamp: time.Now()}
+	w.eventsCh <- testEntries(t, CLEFEvent{Message: "test", Timestamp: time.Now()})[0]
 
 	// Close channels
 	close(w.eventsCh)
@@ -220,3 +315,96 @@ func TestNoFlushMode(t *testing.T) {
 	// Confirm that we never stored anything in retryBuffer
 	assert.Nil(t, w.retryBuffer, "retryBuffer should remain nil/empty in noFlush mode")
 }
+
+func TestAttemptSendBatch_BisectsOnTooLarge(t *testing.T) {
+	// Seq accepts requests of at most 2 events, and never the "huge" one.
+	seq := newFakeSeq(201)
+	seq.respond = func(req *http.Request, body []byte) (int, error) {
+		if bytes.Count(body, []byte("\n")) > 2 || bytes.Contains(body, []byte("huge")) {
+			return 413, nil
+		}
+		return 201, nil
+	}
+	handler := &SeqHandler{
+		client: seq.client(),
+		seqURL: "http://example.com",
+	}
+
+	events := testEntries(t,
+		CLEFEvent{Message: "e1"}, CLEFEvent{Message: "e2"}, CLEFEvent{Message: "huge"}, CLEFEvent{Message: "e4"}, CLEFEvent{Message: "e5"},
+	)
+	leftover, err := handler.attemptSendBatch(context.Background(), nil, events)
+	require.NoError(t, err)
+	assert.Empty(t, leftover)
+
+	all := strings.Join(seq.bodies(), "")
+	for _, m := range []string{"e1", "e2", "e4", "e5"} {
+		assert.Contains(t, all, `"@m":"`+m+`"`)
+	}
+	assert.NotContains(t, all, "huge", "an event Seq rejects on its own should be dropped")
+}
+
+func TestAttemptSendBatch_MaxBatchBytes(t *testing.T) {
+	seq := newFakeSeq(201)
+	handler := &SeqHandler{
+		client:        seq.client(),
+		seqURL:        "http://example.com",
+		maxBatchBytes: 200,
+	}
+
+	events := make([]CLEFEvent, 10)
+	for i := range events {
+		events[i] = CLEFEvent{Message: strings.Repeat("x", 50), Level: "Information"}
+	}
+	_, err := handler.attemptSendBatch(context.Background(), nil, testEntries(t, events...))
+	require.NoError(t, err)
+	assert.Greater(t, seq.calls(), 1)
+	for _, body := range seq.bodies() {
+		assert.LessOrEqual(t, len(body), 200)
+	}
+}
+
+func TestEncodeEntry_MaxEventBytes(t *testing.T) {
+	small := CLEFEvent{Message: "small", Level: "Information"}
+	big := CLEFEvent{
+		Message:    "big " + strings.Repeat("m", 500),
+		Exception:  strings.Repeat("x", 500),
+		Level:      "Error",
+		Properties: map[string]any{"payload": strings.Repeat("p", 1000)},
+	}
+
+	handler := &SeqHandler{maxEventBytes: 300}
+	_, err := encodeTestEvent(handler, small)
+	require.NoError(t, err)
+	_, err = encodeTestEvent(handler, big)
+	require.ErrorIs(t, err, errEventTooLarge, "oversized event should be dropped")
+
+	handler.truncateOversized = true
+	e, err := encodeTestEvent(handler, big)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(e.line), 300)
+	truncated := decodeEntry(t, e)
+	assert.True(t, strings.HasPrefix(truncated.Message, "big "))
+	assert.Equal(t, "Error", truncated.Level)
+	assert.NotContains(t, truncated.Properties, "payload")
+	assert.Greater(t, truncated.Properties[truncatedKey], float64(300))
+}
+
+func TestHandleCLEFEvent_DropsOversizedEvents(t *testing.T) {
+	handler := &SeqHandler{
+		maxEventBytes: 100,
+		workers:       []worker{{eventsCh: make(chan entry, 10)}},
+	}
+
+	handler.HandleCLEFEvent(CLEFEvent{Message: "small"})
+	handler.HandleCLEFEvent(CLEFEvent{Message: strings.Repeat("x", 200)})
+
+	assert.Len(t, handler.workers[0].eventsCh, 1)
+	assert.Equal(t, uint64(1), handler.Stats().Workers[0].DroppedUndeliverable)
+}
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "abc", truncateString("abcdef", 3))
+	assert.Equal(t, "", truncateString("abc", -1))
+	assert.Equal(t, "a", truncateString("aé", 2), "should not split a multi-byte rune")
+}
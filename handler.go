@@ -2,7 +2,10 @@ package slogseq
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"runtime"
 	"strings"
@@ -10,34 +13,201 @@ import (
 	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/trace"
 	"slices"
 )
 
+// Seq's default limits for the size of a single event and of a request.
+const (
+	defaultMaxEventBytes = 256 * 1024
+	defaultMaxBatchBytes = 10 * 1024 * 1024
+)
+
 type worker struct {
-	eventsCh chan CLEFEvent
-	doneCh   chan struct{}
-	wg       sync.WaitGroup
+	eventsCh chan entry
+	// events at or above the priority level, sent as soon as they arrive
+	priorityCh chan entry
+	flushCh    chan flushRequest
+	doneCh     chan struct{}
+	wg         sync.WaitGroup
 	// retry buffer
-	retryBuffer []CLEFEvent
+	retryBuffer []entry
+	// number of events in the current batch and the retry buffer, read when
+	// Flush or Shutdown give up before the worker is done
+	held        atomic.Int64
 	purgeTicker *time.Ticker
+	// backoff after consecutive failures
+	failures    int
+	nextAttempt time.Time
+	lastErr     error
+	// outcome of the final flush, valid once the worker has stopped
+	result flushResult
+	stats  workerStats
+}
+
+// flushRequest asks a worker to send everything it holds.
+type flushRequest struct {
+	ctx  context.Context
+	done chan<- flushResult
+}
+
+type flushResult struct {
+	undelivered int
+	err         error
+}
+
+// handlerState is shared between a handler and the handlers derived from it
+// through WithAttrs and WithGroup.
+type handlerState struct {
+	// minimum level accepted by the Seq server, nil if it accepts everything
+	serverLevel atomic.Pointer[slog.Level]
+	// unix nanoseconds of the last response that could carry a level change
+	lastLevelCheck atomic.Int64
+
+	// mu is held for reading while events are enqueued, so that once closed
+	// is set no event can end up in a worker that is shutting down.
+	mu          sync.RWMutex
+	closed      bool
+	shutdownCtx context.Context
+}
+
+// acquire must be called before enqueueing an event. It returns false if the
+// handler is closed; otherwise release must be called afterwards.
+func (s *handlerState) acquire() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
+func (s *handlerState) release() {
+	if s != nil {
+		s.mu.RUnlock()
+	}
+}
+
+// close marks the handler as closed. It returns false if it already was.
+func (s *handlerState) close(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closed = true
+	s.shutdownCtx = ctx
+	return true
+}
+
+func (s *handlerState) isClosed() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closed
+}
+
+// shutdownContext returns the context passed to Shutdown.
+func (s *handlerState) shutdownContext() context.Context {
+	if s == nil {
+		return context.Background()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.shutdownCtx == nil {
+		return context.Background()
+	}
+	return s.shutdownCtx
+}
+
+// ErrClosed is returned by Flush once the handler has been shut down.
+var ErrClosed = errors.New("slogseq: handler is closed")
+
+// DeliveryError is returned by Flush and Shutdown when not every event could
+// be delivered to Seq.
+type DeliveryError struct {
+	// Undelivered is the number of events held in memory that could not be
+	// sent. After Flush they are kept and retried later, after Shutdown they
+	// are lost.
+	Undelivered int
+	// Spooled is the number of events waiting in the disk spool, which are
+	// replayed once Seq is reachable again, also after a restart.
+	Spooled int
+	// Err is the last error that occurred while sending, if any.
+	Err error
+}
+
+func (e *DeliveryError) Error() string {
+	msg := fmt.Sprintf("slogseq: %d events not delivered", e.Undelivered+e.Spooled)
+	if e.Spooled > 0 {
+		msg += fmt.Sprintf(" (%d spooled)", e.Spooled)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
 }
 
 type SeqHandler struct {
 	// config
-	seqURL           string
-	apiKey           string
-	batchSize        int
-	flushInterval    time.Duration
-	disableTLSVerify bool
-	sourceKey        string
-	workerCount      int
-	nonBlocking      bool
-	noFlush          bool // Used in tests
+	seqURL            string
+	apiKey            string
+	batchSize         int
+	flushInterval     time.Duration
+	disableTLSVerify  bool
+	sourceKey         string
+	workerCount       int
+	queueSize         int
+	overflowPolicy    OverflowPolicy
+	blockTimeout      time.Duration
+	priorityLevel     slog.Leveler
+	memoryLimit       int64
+	noFlush           bool // Used in tests
+	spoolDir          string
+	spoolMaxBytes     int64
+	retryPolicy       RetryPolicy
+	maxEventBytes     int
+	maxBatchBytes     int
+	truncateOversized bool
+	compression       Compression
+	protocol          Protocol
+	gzipPool          *sync.Pool
+	errorHandler      func(error)
+	meterProvider     metric.MeterProvider
+	messageTemplates  bool
+	reservedKeyPolicy ReservedKeyPolicy
+	levelMapper       func(slog.Level) CLEFLevel
+	errorKeys         []string
+	valueConverters   []ValueConverter
+	limits            Limits
+	resource          map[string]any // @ra of events logged through slog
 
 	// http client
 	client *http.Client
 
+	// durable storage for undeliverable batches
+	spool *spool
+	// why the spool could not be opened, if it couldn't
+	spoolErr error
+
+	// bytes of events held in memory, shared with derived handlers
+	memory *memoryBudget
+
+	// self-monitoring, nil unless WithMeterProvider is set
+	metrics *instruments
+
+	state *handlerState
+
 	// concurrency
 	workers []worker
 	next    uint32
@@ -55,10 +225,16 @@ func newSeqHandler(seqURL string) *SeqHandler {
 		batchSize:     50,
 		flushInterval: 2 * time.Second,
 		workerCount:   1,
-		nonBlocking:   true,
+		queueSize:     defaultQueueSize,
+		priorityLevel: slog.LevelWarn,
 		noFlush:       false,
 		sourceKey:     slog.SourceKey,
+		retryPolicy:   DefaultRetryPolicy(),
+		maxEventBytes: defaultMaxEventBytes,
+		maxBatchBytes: defaultMaxBatchBytes,
+		errorKeys:     defaultErrorKeys,
 		options:       slog.HandlerOptions{},
+		state:         &handlerState{},
 	}
 
 	return h
@@ -68,10 +244,37 @@ func (h *SeqHandler) start() {
 	if h.client == nil {
 		h.client = newHttpClient(h.disableTLSVerify)
 	}
+	if h.state == nil {
+		h.state = &handlerState{}
+	}
+	if h.spoolDir != "" {
+		s, err := openSpool(h.spoolDir, h.spoolMaxBytes)
+		if err != nil {
+			// carry on without one rather than lose events in memory as well
+			h.spoolErr = fmt.Errorf("slogseq: opening spool: %w", err)
+			h.reportError(h.spoolErr)
+		}
+		h.spool = s
+	}
 	h.workers = make([]worker, h.workerCount)
+	if h.queueSize <= 0 {
+		h.queueSize = defaultQueueSize
+	}
+	if h.memoryLimit > 0 {
+		h.memory = newMemoryBudget(h.memoryLimit)
+	}
+	if h.meterProvider != nil {
+		if m, err := newInstruments(h.meterProvider, h); err == nil {
+			h.metrics = m
+		}
+	}
 	// Start background workers
 	for i := range h.workerCount {
-		h.workers[i].eventsCh = make(chan CLEFEvent, 1000)
+		h.workers[i].eventsCh = make(chan entry, h.queueSize)
+		if h.priorityLevel != nil {
+			h.workers[i].priorityCh = make(chan entry, h.queueSize)
+		}
+		h.workers[i].flushCh = make(chan flushRequest)
 		h.workers[i].doneCh = make(chan struct{})
 		h.workers[i].wg.Add(1)
 		go h.runBackgroundFlusher(&h.workers[i])
@@ -80,12 +283,13 @@ func (h *SeqHandler) start() {
 
 func (h *SeqHandler) Handle(ctx context.Context, r slog.Record) error {
 	// Convert slog.Level to text
-	levelString := convertLevel(r.Level)
+	levelString := h.clefLevel(r.Level).String()
 
 	spanCtx := trace.SpanContextFromContext(ctx)
 
-	// Collect attributes into a map
-	props := make(map[string]any)
+	// Collect attributes, in order, with dotted keys and groups as nested objects
+	props := getPropTree()
+	defer putPropTree(props)
 
 	if h.options.AddSource {
 		pc := r.PC
@@ -95,7 +299,10 @@ func (h *SeqHandler) Handle(ctx context.Context, r slog.Record) error {
 		sourceAttr := slog.Any(h.sourceKey, &source)
 		r.AddAttrs(sourceAttr)
 	}
-	h.addAttrs(props, h.attrs)
+	for _, a := range h.attrs {
+		props.addAttr(rootNode, a, true)
+	}
+	var exceptions []string
 	r.Attrs(func(a slog.Attr) bool {
 		if h.options.ReplaceAttr != nil {
 			a = h.options.ReplaceAttr(h.groups, a)
@@ -104,66 +311,107 @@ func (h *SeqHandler) Handle(ctx context.Context, r slog.Record) error {
 			}
 		}
 
-		if len(h.groups) > 0 && a.Key != h.sourceKey {
-			a.Key = strings.Join(h.groups, ".") + "." + a.Key
+		if a.Value.Kind() == slog.KindAny {
+			if v, ok := a.Value.Any().(error); ok {
+				if slices.Contains(h.errorKeys, a.Key) {
+					exceptions = append(exceptions, renderException(v))
+				}
+				a.Value = slog.StringValue(v.Error())
+			}
 		}
 
-		if v, ok := a.Value.Any().(error); ok {
-			a.Value = slog.StringValue(v.Error())
+		parent := rootNode
+		if a.Key != h.sourceKey {
+			for _, g := range h.groups {
+				parent = props.path(parent, g)
+			}
 		}
-		h.addAttr(props, a)
+		props.addAttr(parent, a, true)
 		return true
 	})
 
 	// split multi-line messages into a message (first line) and 'exception' (rest)
-	msg := strings.SplitN(r.Message, "\n", 2)
-
-	var exception string
-	if len(msg) == 1 {
-		exception = ""
-	} else {
-		exception = msg[1]
+	message, exception, _ := strings.Cut(r.Message, "\n")
+	if len(exceptions) > 0 {
+		if exception != "" {
+			exceptions = append([]string{exception}, exceptions...)
+		}
+		exception = strings.Join(exceptions, "\n")
 	}
 
 	// Create CLEF event
 	event := CLEFEvent{
-		Timestamp:  r.Time,
-		Message:    msg[0],
-		Exception:  exception,
-		Level:      levelString,
-		Properties: dottedToNested(props),
+		Timestamp:          r.Time,
+		Message:            message,
+		Exception:          exception,
+		Level:              levelString,
+		ResourceAttributes: h.resource,
+	}
+	if h.messageTemplates {
+		event.Message = ""
+		event.MessageTemplate = message
+		event.EventType = eventTypeID(message)
+		event.Renderings = templateRenderings(parseTemplateHoles(message), props.lookup)
 	}
 	if spanCtx.IsValid() {
 		event.TraceID = spanCtx.TraceID().String()
 		event.SpanID = spanCtx.SpanID().String()
 	}
-	h.HandleCLEFEvent(event)
+	h.handleEvent(&event, props)
 
 	return nil
 }
 
+// HandleCLEFEvent sends an event that was put together by the caller, such as
+// one converted from an OpenTelemetry span. Its properties are written in key
+// order. Without resource attributes of its own, it gets those set with
+// WithResource.
 func (h *SeqHandler) HandleCLEFEvent(event CLEFEvent) {
+	if event.ResourceAttributes == nil {
+		event.ResourceAttributes = h.resource
+	}
+	props := getPropTree()
+	defer putPropTree(props)
+	for _, k := range slices.Sorted(maps.Keys(event.Properties)) {
+		props.set(rootNode, k, slog.AnyValue(event.Properties[k]))
+	}
+	h.handleEvent(&event, props)
+}
+
+// handleEvent encodes an event and hands it to the next worker.
+func (h *SeqHandler) handleEvent(event *CLEFEvent, props *propTree) {
+	if !h.state.acquire() {
+		return // shut down, drop event
+	}
+	defer h.state.release()
+
 	idx := atomic.AddUint32(&h.next, 1) % uint32(len(h.workers))
-	if h.nonBlocking {
-		// send to channel, drop if full
-		select {
-		case h.workers[idx].eventsCh <- event:
-			// success
-		default:
-			// channel full, drop event
-		}
-	} else {
-		// blocking send
-		select {
-		case h.workers[idx].eventsCh <- event:
-			// success
-		}
+	w := &h.workers[idx]
+
+	e, err := h.encodeEntry(event, props)
+	if err != nil {
+		// too large for Seq
+		h.recordDropped(w, dropUndeliverable, 1)
+		return
 	}
+	ch := w.eventsCh
+	if w.priorityCh != nil && h.isPriority(event.Level) {
+		// keep important events from getting stuck behind a flood of others
+		ch = w.priorityCh
+	}
+	h.enqueue(w, ch, e)
 }
 
 func (h *SeqHandler) Enabled(ctx context.Context, l slog.Level) bool {
-	if h.options.Level != nil {
-		return l >= h.options.Level.Level()
+	if h.options.Level != nil && l < h.options.Level.Level() {
+		return false
+	}
+	// Seq can ask clients to not send events it would discard anyway. It
+	// judges events by their CLEF level, so compare on that.
+	if minimum, ok := h.state.minimumLevelAccepted(); ok {
+		if level, ok := parseCLEFLevel(h.clefLevel(l).String()); ok && level < minimum {
+			return false
+		}
 	}
 	return true
 }
@@ -197,92 +445,114 @@ func (h *SeqHandler) WithGroup(name string) slog.Handler {
 	return &h2
 }
 
+// Close shuts the handler down, see Shutdown. It waits for as long as it
+// takes to deliver the remaining events.
 func (h *SeqHandler) Close() error {
-	// this is ugly, but we need to give all the events a chance to be sent
-	time.Sleep(50 * time.Millisecond)
-	for i := range h.workerCount {
-		close(h.workers[i].eventsCh)
-		close(h.workers[i].doneCh)
-		h.workers[i].wg.Wait()
-	}
-	return nil
+	return h.Shutdown(context.Background())
 }
 
-// SourceKey returns the key used when AddSource is enabled.
-func (h *SeqHandler) SourceKey() string {
-	return h.sourceKey
-}
-
-func (h *SeqHandler) addAttrs(dst map[string]any, attrs []slog.Attr) {
-	for _, a := range attrs {
-		h.addAttr(dst, a)
+// Flush sends all events handled so far, including those waiting to be
+// retried or in the spool, and waits until Seq has accepted them or ctx is
+// done. Events that could not be delivered are kept for a later retry and
+// reported in a *DeliveryError.
+func (h *SeqHandler) Flush(ctx context.Context) error {
+	if h.noFlush {
+		return nil
 	}
-}
-
-func (h *SeqHandler) addAttr(dst map[string]any, a slog.Attr) {
-	a.Value = a.Value.Resolve()
+	// Hold off Shutdown until the workers have answered.
+	if !h.state.acquire() {
+		return ErrClosed
+	}
+	defer h.state.release()
 
-	if a.Key == "" {
-		// Anonymous group, inline
-		if a.Value.Kind() == slog.KindGroup {
-			for _, ga := range a.Value.Group() {
-				h.addAttr(dst, ga)
-			}
+	results := make(chan flushResult, len(h.workers))
+	pending := 0
+	for i := range h.workers {
+		select {
+		case h.workers[i].flushCh <- flushRequest{ctx: ctx, done: results}:
+			pending++
+		case <-ctx.Done():
+			return &DeliveryError{Err: ctx.Err()}
 		}
-		return
 	}
 
-	switch a.Value.Kind() {
-	case slog.KindGroup:
-		groupMap, ok := dst[a.Key].(map[string]any)
-		if !ok {
-			groupMap = make(map[string]any)
-			dst[a.Key] = groupMap
-		}
-		for _, ga := range a.Value.Group() {
-			h.addAttr(groupMap, ga)
+	var derr DeliveryError
+	for ; pending > 0; pending-- {
+		select {
+		case res := <-results:
+			derr.Undelivered += res.undelivered
+			if res.err != nil {
+				derr.Err = res.err
+			}
+		case <-ctx.Done():
+			// count what every worker still has, answered or not
+			derr.Undelivered = 0
+			for i := range h.workers {
+				derr.Undelivered += h.workers[i].undelivered()
+			}
+			derr.Spooled = h.spool.pending()
+			derr.Err = ctx.Err()
+			return &derr
 		}
-	default:
-		dst[a.Key] = a.Value.Any()
 	}
-}
-
-func dottedToNested(props map[string]any) map[string]any {
-	out := make(map[string]any, len(props))
-	for k, v := range props {
-		path := strings.Split(k, ".")
-		addNested(out, path, v)
+	derr.Spooled = h.spool.pending()
+	if derr.Undelivered > 0 || derr.Spooled > 0 {
+		return &derr
 	}
-	return out
+	return nil
 }
 
-func addNested(dst map[string]any, path []string, val any) {
-	if len(path) == 1 {
-		dst[path[0]] = val
-		return
+// Shutdown stops accepting events, sends everything still queued or waiting
+// to be retried, and stops the workers. It waits until that is done or ctx is
+// done. Events that could not be delivered are lost, unless they are kept in
+// the spool, and reported in a *DeliveryError. Calling Shutdown more than
+// once is safe; subsequent calls return nil.
+func (h *SeqHandler) Shutdown(ctx context.Context) error {
+	if !h.state.close(ctx) {
+		return nil
+	}
+	for i := range h.workers {
+		close(h.workers[i].doneCh)
 	}
 
-	head := path[0]
-	child, ok := dst[head].(map[string]any)
-	if !ok {
-		child = make(map[string]any)
-		dst[head] = child
+	stopped := make(chan struct{})
+	go func() {
+		for i := range h.workers {
+			h.workers[i].wg.Wait()
+		}
+		close(stopped)
+	}()
+
+	defer h.metrics.unregister()
+
+	var derr DeliveryError
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// The workers give up as well, as their requests use ctx.
+		derr.Err = ctx.Err()
+		for i := range h.workers {
+			derr.Undelivered += h.workers[i].undelivered()
+		}
+		derr.Spooled = h.spool.pending()
+		return &derr
 	}
 
-	addNested(child, path[1:], val)
+	for i := range h.workers {
+		res := h.workers[i].result
+		derr.Undelivered += res.undelivered
+		if res.err != nil {
+			derr.Err = res.err
+		}
+	}
+	derr.Spooled = h.spool.pending()
+	if derr.Undelivered > 0 || derr.Spooled > 0 {
+		return &derr
+	}
+	return nil
 }
 
-func convertLevel(l slog.Level) string {
-	switch l {
-	case slog.LevelDebug:
-		return CLEFLevelDebug.String()
-	case slog.LevelInfo:
-		return CLEFLevelInformation.String()
-	case slog.LevelWarn:
-		return CLEFLevelWarning.String()
-	case slog.LevelError:
-		return CLEFLevelError.String()
-	default:
-		return CLEFLevelInformation.String()
-	}
+// SourceKey returns the key used when AddSource is enabled.
+func (h *SeqHandler) SourceKey() string {
+	return h.sourceKey
 }
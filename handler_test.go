@@ -2,6 +2,7 @@ package slogseq
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"strings"
 	"testing"
@@ -57,7 +58,8 @@ func TestSeqHandler_Handle(t *testing.T) {
 	logger.Info("Hello, slog-seq!", "user", "alice", "count", 123)
 
 	select {
-	case evt := <-handler.workers[0].eventsCh:
+	case e := <-handler.workers[0].eventsCh:
+		evt := decodeEntry(t, e)
 		if evt.Message != "Hello, slog-seq!" {
 			t.Errorf("Expected message 'Hello, slog-seq!', got '%s'", evt.Message)
 		}
@@ -67,7 +69,7 @@ func TestSeqHandler_Handle(t *testing.T) {
 		if evt.Properties["user"] != "alice" {
 			t.Errorf("Expected user=alice, got %v", evt.Properties["user"])
 		}
-		if evt.Properties["count"].(int64) != 123 {
+		if evt.Properties["count"] != float64(123) {
 			t.Errorf("Expected count=123, got %v", evt.Properties["count"])
 		}
 	case <-time.After(2000 * time.Millisecond):
@@ -119,7 +121,8 @@ func TestSeqHandler_WithAttrs(t *testing.T) {
 	logger2.Info("WithAttrs test", "version", "1.2.3")
 
 	select {
-	case evt := <-handler.workers[0].eventsCh:
+	case e := <-handler.workers[0].eventsCh:
+		evt := decodeEntry(t, e)
 		// Should have both service=testsvc and version=1.2.3
 		if evt.Properties["service"] != "testsvc" {
 			t.Errorf("Expected service=testsvc, got %v", evt.Properties["service"])
@@ -149,7 +152,8 @@ func TestSeqHandler_WithGroup(t *testing.T) {
 	grouped.Info("Grouped log")
 
 	select {
-	case evt := <-handler.workers[0].eventsCh:
+	case e := <-handler.workers[0].eventsCh:
+		evt := decodeEntry(t, e)
 		// We expect keys to be "request.id" and "request.headers.Accept"
 		request := evt.Properties["request"].(map[string]any)
 		headers := request["headers"].(map[string]any)
@@ -180,8 +184,8 @@ func TestSeqHandler_Close(t *testing.T) {
 	// but we can't do that directly without instrumentation or reflection.
 }
 
-// TestSeqHandler_convertLevel ensures level conversion matches expectations.
-func TestSeqHandler_convertLevel(t *testing.T) {
+// TestSeqHandler_clefLevel ensures level conversion matches expectations.
+func TestSeqHandler_clefLevel(t *testing.T) {
 	cases := []struct {
 		in       slog.Level
 		expected string
@@ -190,13 +194,17 @@ func TestSeqHandler_convertLevel(t *testing.T) {
 		{slog.LevelInfo, "Information"},
 		{slog.LevelWarn, "Warning"},
 		{slog.LevelError, "Error"},
-		{42, "Information"}, // Something out of range
+		{slog.LevelDebug - 4, "Verbose"},
+		{slog.LevelWarn + 2, "Warning"},
+		{slog.LevelError + 4, "Fatal"},
+		{42, "Fatal"}, // Something out of range
 	}
 
+	h := &SeqHandler{}
 	for _, c := range cases {
-		out := convertLevel(c.in)
+		out := h.clefLevel(c.in).String()
 		if out != c.expected {
-			t.Errorf("convertLevel(%v) = %s, want %s", c.in, out, c.expected)
+			t.Errorf("clefLevel(%v) = %s, want %s", c.in, out, c.expected)
 		}
 	}
 }
@@ -218,22 +226,21 @@ func TestSeqHandler_addSource(t *testing.T) {
 	logger.Info("Hello, slog-seq!", "user", "alice", "count", 123)
 
 	select {
-	case evt := <-handler.workers[0].eventsCh:
+	case e := <-handler.workers[0].eventsCh:
+		evt := decodeEntry(t, e)
 		if evt.Properties["gosource"] == nil {
 			t.Error("Expected gosource to be set")
 		}
-		source := evt.Properties["gosource"].(*slog.Source)
-		if source.File == "" {
+		source, _ := evt.Properties["gosource"].(map[string]any)
+		if file, _ := source["file"].(string); file == "" {
 			t.Error("Expected source file to be set")
 		}
-		if source.Line == 0 {
+		if line, _ := source["line"].(float64); line == 0 {
 			t.Error("Expected source line to be set")
 		}
-		if source.Function == "" {
-			t.Error("Expected source function to be set")
-		}
-		if !strings.Contains(source.Function, "TestSeqHandler_addSource") {
-			t.Errorf("Expected source function to contain TestSeqHandler_addSource, got %s", source.Function)
+		function, _ := source["function"].(string)
+		if !strings.Contains(function, "TestSeqHandler_addSource") {
+			t.Errorf("Expected source function to contain TestSeqHandler_addSource, got %s", function)
 		}
 	case <-time.After(2000 * time.Millisecond):
 		t.Error("Timed out waiting for log event in eventsCh")
@@ -259,8 +266,8 @@ func TestSeqHandler_grouping(t *testing.T) {
 	logger.WithGroup("s").LogAttrs(ctx, slog.LevelInfo, "huba", slog.Int("a", 1), slog.Int("b", 2))
 	logger.LogAttrs(ctx, slog.LevelInfo, "huba", slog.Group("s", slog.Int("a", 1), slog.Int("b", 2)))
 
-	event1 := <-handler.workers[0].eventsCh
-	event2 := <-handler.workers[0].eventsCh
+	event1 := decodeEntry(t, <-handler.workers[0].eventsCh)
+	event2 := decodeEntry(t, <-handler.workers[0].eventsCh)
 
 	if diff := cmp.Diff(event1, event2, cmpopts.IgnoreFields(CLEFEvent{}, "Timestamp")); diff != "" {
 		t.Errorf("events differ: (-got +want)\n%s", diff)
@@ -290,8 +297,8 @@ func TestSeqHandler_replaceAttr(t *testing.T) {
 	logger.Info("Super secret info", "password", "2Fat2Fly")
 	logger.WithGroup("secret_info").Info("Wohoo", "password", "secret")
 
-	event1 := <-handler.workers[0].eventsCh
-	event2 := <-handler.workers[0].eventsCh
+	event1 := decodeEntry(t, <-handler.workers[0].eventsCh)
+	event2 := decodeEntry(t, <-handler.workers[0].eventsCh)
 
 	if event1.Properties["password"] != "*****" {
 		t.Errorf("Expected password=*****, got %v", event1.Properties["password"])
@@ -334,11 +341,11 @@ func TestSeqHandler_AnonymousGroup(t *testing.T) {
 	logger.With("", payload{ID: 42, Name: "keyname"}).
 		Info("anon-group-with")
 
-	evt1 := <-handler.workers[0].eventsCh
-	evt2 := <-handler.workers[0].eventsCh
+	evt1 := decodeEntry(t, <-handler.workers[0].eventsCh)
+	evt2 := decodeEntry(t, <-handler.workers[0].eventsCh)
 
 	// --- Assertions for the first event (argument style) -----------
-	if got := evt1.Properties["id"]; got != int64(42) {
+	if got := evt1.Properties["id"]; got != float64(42) {
 		t.Errorf("argument style: expected id=42, got %v", got)
 	}
 	if got := evt1.Properties["name"]; got != "keyname" {
@@ -346,7 +353,7 @@ func TestSeqHandler_AnonymousGroup(t *testing.T) {
 	}
 
 	// --- Assertions for the second event (With style) --------------
-	if got := evt2.Properties["id"]; got != int64(42) {
+	if got := evt2.Properties["id"]; got != float64(42) {
 		t.Errorf("With style: expected id=42, got %v", got)
 	}
 	if got := evt2.Properties["name"]; got != "keyname" {
@@ -360,3 +367,179 @@ func TestSeqHandler_AnonymousGroup(t *testing.T) {
 		t.Errorf("events differ: (-arg +with)\n%s", diff)
 	}
 }
+
+// TestSeqHandler_Flush checks that Flush delivers queued events right away.
+func TestSeqHandler_Flush(t *testing.T) {
+	seq := newFakeSeq(201)
+	logger, handler := NewLogger("http://fake",
+		WithHTTPClient(seq.client()),
+		WithBatchSize(100),
+		WithFlushInterval(time.Hour),
+		WithWorkers(2),
+	)
+	defer handler.Close()
+
+	for i := range 5 {
+		logger.Info("flush me", "i", i)
+	}
+	if err := handler.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush returned error: %v", err)
+	}
+	if got := strings.Count(strings.Join(seq.bodies(), ""), "flush me"); got != 5 {
+		t.Errorf("expected 5 events to be delivered, got %d", got)
+	}
+}
+
+// TestSeqHandler_Shutdown checks that Shutdown delivers queued events and can be called more than once.
+func TestSeqHandler_Shutdown(t *testing.T) {
+	seq := newFakeSeq(201)
+	logger, handler := NewLogger("http://fake",
+		WithHTTPClient(seq.client()),
+		WithBatchSize(100),
+		WithFlushInterval(time.Hour),
+	)
+
+	for i := range 5 {
+		logger.Info("shut down", "i", i)
+	}
+	if err := handler.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+	if err := handler.Shutdown(context.Background()); err != nil {
+		t.Errorf("second Shutdown returned error: %v", err)
+	}
+	if err := handler.Close(); err != nil {
+		t.Errorf("Close after Shutdown returned error: %v", err)
+	}
+	if err := handler.Flush(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed from Flush after Shutdown, got %v", err)
+	}
+
+	// logging after shutdown is dropped, not a panic
+	logger.Info("too late")
+	all := strings.Join(seq.bodies(), "")
+	if got := strings.Count(all, "shut down"); got != 5 {
+		t.Errorf("expected 5 events to be delivered, got %d", got)
+	}
+	if strings.Contains(all, "too late") {
+		t.Error("event logged after Shutdown should not be delivered")
+	}
+}
+
+// TestSeqHandler_ShutdownReportsUndelivered checks that events Seq doesn't accept are reported.
+func TestSeqHandler_ShutdownReportsUndelivered(t *testing.T) {
+	logger, handler := NewLogger("http://fake",
+		WithHTTPClient(newFakeSeq(503).client()),
+		WithBatchSize(100),
+		WithFlushInterval(time.Hour),
+	)
+
+	for i := range 3 {
+		logger.Info("lost", "i", i)
+	}
+	err := handler.Shutdown(context.Background())
+	var derr *DeliveryError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected a DeliveryError, got %v", err)
+	}
+	if derr.Undelivered != 3 {
+		t.Errorf("expected 3 undelivered events, got %d", derr.Undelivered)
+	}
+}
+
+// TestSeqHandler_ShutdownDeadline checks that Shutdown gives up when the context is done.
+func TestSeqHandler_ShutdownDeadline(t *testing.T) {
+	logger, handler := NewLogger("http://fake",
+		WithHTTPClient(newFakeSeq(201).block().client()),
+		WithFlushInterval(time.Hour),
+	)
+	logger.Info("stuck")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := handler.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v, expected it to respect the deadline", elapsed)
+	}
+	// the event is in the worker's batch rather than in its queue
+	var derr *DeliveryError
+	if !errors.As(err, &derr) || derr.Undelivered != 1 {
+		t.Errorf("expected 1 undelivered event, got %v", err)
+	}
+}
+
+// TestSeqHandler_FlushDeadline checks that Flush counts the events the workers still hold when the context is done.
+func TestSeqHandler_FlushDeadline(t *testing.T) {
+	logger, handler := NewLogger("http://fake",
+		WithHTTPClient(newFakeSeq(201).block().client()),
+		WithFlushInterval(time.Hour),
+		WithPriorityLevel(nil),
+	)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_ = handler.Shutdown(ctx)
+	}()
+	logger.Info("stuck")
+	logger.Info("stuck too")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := handler.Flush(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	var derr *DeliveryError
+	if !errors.As(err, &derr) || derr.Undelivered != 2 {
+		t.Errorf("expected 2 undelivered events, got %v", err)
+	}
+}
+
+// TestSeqHandler_reservedKeys checks that attributes named like CLEF fields don't overwrite them.
+func TestSeqHandler_reservedKeys(t *testing.T) {
+	cases := []struct {
+		policy   ReservedKeyPolicy
+		expected map[string]any
+		message  string
+	}{
+		{ReservedKeyEscape, map[string]any{"@@m": "user message", "@@@x": "already escaped", "ok": "yes"}, "real message"},
+		{ReservedKeyDrop, map[string]any{"ok": "yes"}, "real message"},
+		{ReservedKeyOverwrite, map[string]any{"@@x": "already escaped", "ok": "yes"}, "user message"},
+	}
+
+	for _, c := range cases {
+		handler := newQueueingHandler(WithReservedKeyPolicy(c.policy))
+
+		slog.New(handler).Info("real message", "@m", "user message", "@@x", "already escaped", "ok", "yes")
+		evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+		handler.Close()
+
+		if diff := cmp.Diff(c.expected, evt.Properties); diff != "" {
+			t.Errorf("policy %d: properties differ: (-want +got)\n%s", c.policy, diff)
+		}
+		if evt.Message != c.message {
+			t.Errorf("policy %d: expected @m to be %q, got %q", c.policy, c.message, evt.Message)
+		}
+	}
+}
+
+// TestSeqHandler_HandleCLEFEventReservedKeys checks that events passed in directly are escaped too.
+func TestSeqHandler_HandleCLEFEventReservedKeys(t *testing.T) {
+	handler := newQueueingHandler()
+	defer handler.Close()
+
+	props := map[string]any{"@t": "not a timestamp"}
+	handler.HandleCLEFEvent(CLEFEvent{Message: "direct", Properties: props})
+	evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+
+	if evt.Properties["@@t"] != "not a timestamp" {
+		t.Errorf("expected @t to be escaped to @@t, got %v", evt.Properties)
+	}
+	if _, ok := props["@@t"]; ok {
+		t.Error("the caller's properties should not be modified")
+	}
+}
@@ -0,0 +1,142 @@
+package slogseq
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Levels for the CLEF levels that slog has no constant for.
+const (
+	LevelVerbose = slog.LevelDebug - 4
+	LevelFatal   = slog.LevelError + 4
+)
+
+// DefaultLevelMapper maps slog levels onto CLEF levels by range, so levels in
+// between the slog constants end up at the level below them: LevelWarn+2 is
+// Warning, anything below LevelDebug is Verbose and anything from LevelFatal
+// up is Fatal.
+func DefaultLevelMapper(l slog.Level) CLEFLevel {
+	switch {
+	case l < slog.LevelDebug:
+		return CLEFLevelVerbose
+	case l < slog.LevelInfo:
+		return CLEFLevelDebug
+	case l < slog.LevelWarn:
+		return CLEFLevelInformation
+	case l < slog.LevelError:
+		return CLEFLevelWarning
+	case l < LevelFatal:
+		return CLEFLevelError
+	default:
+		return CLEFLevelFatal
+	}
+}
+
+// clefLevel maps a slog level using the configured level mapper.
+func (h *SeqHandler) clefLevel(l slog.Level) CLEFLevel {
+	if h.levelMapper != nil {
+		return h.levelMapper(l)
+	}
+	return DefaultLevelMapper(l)
+}
+
+// levelCheckInterval is how often an empty batch is sent to Seq to learn about
+// changes to the minimum accepted level when no events are being sent.
+const levelCheckInterval = 2 * time.Minute
+
+// ingestionResponse is the body Seq returns from the ingestion endpoint.
+type ingestionResponse struct {
+	// MinimumLevelAccepted is null if Seq accepts all levels. It is a raw
+	// message so a missing field can be told apart from an explicit null.
+	MinimumLevelAccepted json.RawMessage
+}
+
+// minimumLevelAccepted returns the minimum level Seq has asked for, if any.
+func (s *handlerState) minimumLevelAccepted() (slog.Level, bool) {
+	if s == nil {
+		return 0, false
+	}
+	l := s.serverLevel.Load()
+	if l == nil {
+		return 0, false
+	}
+	return *l, true
+}
+
+// updateMinimumLevelAccepted reads an ingestion response body and updates the
+// minimum level accepted by Seq accordingly.
+func (s *handlerState) updateMinimumLevelAccepted(body io.Reader) {
+	if s == nil {
+		return
+	}
+	s.lastLevelCheck.Store(time.Now().UnixNano())
+
+	var resp ingestionResponse
+	if err := json.NewDecoder(io.LimitReader(body, 64*1024)).Decode(&resp); err != nil {
+		return // not a Seq response, leave things as they are
+	}
+	if resp.MinimumLevelAccepted == nil {
+		return
+	}
+	var name *string
+	if err := json.Unmarshal(resp.MinimumLevelAccepted, &name); err != nil {
+		return
+	}
+	if name == nil {
+		s.serverLevel.Store(nil)
+		return
+	}
+	if l, ok := parseCLEFLevel(*name); ok {
+		s.serverLevel.Store(&l)
+	}
+}
+
+// levelCheckDue reports whether Seq should be asked for its minimum level
+// because nothing has been sent for a while.
+func (s *handlerState) levelCheckDue() bool {
+	if _, ok := s.minimumLevelAccepted(); !ok {
+		return false
+	}
+	last := time.Unix(0, s.lastLevelCheck.Load())
+	return time.Since(last) >= levelCheckInterval
+}
+
+// parseCLEFLevel converts a level name as used by Seq to a slog.Level. It is
+// the reverse of DefaultLevelMapper, and also knows the abbreviations Seq
+// accepts on ingestion.
+func parseCLEFLevel(name string) (slog.Level, bool) {
+	// the names the handler writes itself, without lowercasing
+	switch name {
+	case "Verbose":
+		return LevelVerbose, true
+	case "Debug":
+		return slog.LevelDebug, true
+	case "Information":
+		return slog.LevelInfo, true
+	case "Warning":
+		return slog.LevelWarn, true
+	case "Error":
+		return slog.LevelError, true
+	case "Fatal":
+		return LevelFatal, true
+	}
+	switch strings.ToLower(name) {
+	case "verbose", "trace", "vrb", "trc":
+		return LevelVerbose, true
+	case "debug", "dbg":
+		return slog.LevelDebug, true
+	case "information", "info", "inf":
+		return slog.LevelInfo, true
+	case "warning", "warn", "wrn":
+		return slog.LevelWarn, true
+	case "error", "err", "eror":
+		return slog.LevelError, true
+	case "fatal", "critical", "ftl", "crit":
+		return LevelFatal, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,123 @@
+package slogseq
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinimumLevelAccepted(t *testing.T) {
+	seq := newFakeSeq(201)
+	seq.response = `{"MinimumLevelAccepted":"Warning"}`
+	handler := newSeqHandler("http://example.com")
+	handler.client = seq.client()
+	ctx := context.Background()
+
+	assert.True(t, handler.Enabled(ctx, slog.LevelInfo), "everything is enabled before Seq says otherwise")
+
+	assert.NoError(t, handler.postBatch(context.Background(), nil, []entry{{line: []byte(`{"@m":"hello"}` + "\n")}}))
+	assert.False(t, handler.Enabled(ctx, slog.LevelInfo))
+	assert.True(t, handler.Enabled(ctx, slog.LevelWarn))
+
+	// derived handlers share the level
+	derived := handler.WithAttrs([]slog.Attr{slog.String("a", "b")})
+	assert.False(t, derived.Enabled(ctx, slog.LevelInfo))
+
+	// a response without the field leaves the level alone
+	seq.response = `{}`
+	assert.NoError(t, handler.postBatch(context.Background(), nil, nil))
+	assert.False(t, handler.Enabled(ctx, slog.LevelInfo))
+
+	// null means Seq accepts everything again
+	seq.response = `{"MinimumLevelAccepted":null}`
+	assert.NoError(t, handler.postBatch(context.Background(), nil, nil))
+	assert.True(t, handler.Enabled(ctx, slog.LevelDebug))
+}
+
+func TestMinimumLevelAccepted_CombinedWithHandlerOptions(t *testing.T) {
+	handler := newSeqHandler("http://example.com")
+	handler.options.Level = slog.LevelError
+	seq := newFakeSeq(201)
+	seq.response = `{"MinimumLevelAccepted":"Debug"}`
+	handler.client = seq.client()
+	ctx := context.Background()
+
+	assert.NoError(t, handler.postBatch(context.Background(), nil, nil))
+	assert.False(t, handler.Enabled(ctx, slog.LevelWarn), "the handler's own level still applies")
+	assert.True(t, handler.Enabled(ctx, slog.LevelError))
+}
+
+func TestLevelCheckDue(t *testing.T) {
+	state := &handlerState{}
+	assert.False(t, state.levelCheckDue(), "no check needed while Seq accepts everything")
+
+	l := slog.LevelWarn
+	state.serverLevel.Store(&l)
+	assert.True(t, state.levelCheckDue())
+
+	state.updateMinimumLevelAccepted(strings.NewReader(""))
+	assert.False(t, state.levelCheckDue())
+}
+
+func TestDefaultLevelMapper(t *testing.T) {
+	cases := []struct {
+		in       slog.Level
+		expected CLEFLevel
+	}{
+		{slog.LevelDebug - 8, CLEFLevelVerbose},
+		{LevelVerbose, CLEFLevelVerbose},
+		{slog.LevelDebug - 1, CLEFLevelVerbose},
+		{slog.LevelDebug, CLEFLevelDebug},
+		{slog.LevelInfo - 1, CLEFLevelDebug},
+		{slog.LevelInfo, CLEFLevelInformation},
+		{slog.LevelWarn, CLEFLevelWarning},
+		{slog.LevelWarn + 2, CLEFLevelWarning},
+		{slog.LevelError, CLEFLevelError},
+		{slog.LevelError + 3, CLEFLevelError},
+		{LevelFatal, CLEFLevelFatal},
+		{42, CLEFLevelFatal},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, DefaultLevelMapper(c.in), "level %v", c.in)
+	}
+}
+
+func TestParseCLEFLevel_RoundTrip(t *testing.T) {
+	for _, l := range []slog.Level{LevelVerbose, slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError, LevelFatal} {
+		parsed, ok := parseCLEFLevel(DefaultLevelMapper(l).String())
+		assert.True(t, ok)
+		assert.Equal(t, l, parsed)
+	}
+
+	l, ok := parseCLEFLevel("WRN")
+	assert.True(t, ok)
+	assert.Equal(t, slog.LevelWarn, l)
+	_, ok = parseCLEFLevel("loud")
+	assert.False(t, ok)
+}
+
+func TestWithLevelMapper(t *testing.T) {
+	handler := newQueueingHandler(
+		WithLevelMapper(func(l slog.Level) CLEFLevel {
+			if l >= slog.LevelWarn {
+				return CLEFLevelError
+			}
+			return CLEFLevelInformation
+		}),
+	)
+	defer handler.Close()
+
+	slog.New(handler).Warn("mapped")
+	evt := decodeEntry(t, <-handler.workers[0].priorityCh)
+	assert.Equal(t, CLEFLevelError.String(), evt.Level)
+
+	// Seq's minimum level is compared against the mapped level
+	l := slog.LevelError
+	handler.state.serverLevel.Store(&l)
+	ctx := context.Background()
+	assert.True(t, handler.Enabled(ctx, slog.LevelWarn))
+	assert.False(t, handler.Enabled(ctx, slog.LevelInfo))
+}
@@ -0,0 +1,138 @@
+package slogseq
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Limits caps the size of property values, so that a single huge or deeply
+// nested attribute can't make an event too large for Seq. Whatever exceeds a
+// limit is left out, and the event gets a _limits property listing the
+// properties that were cut. 0 means no limit.
+type Limits struct {
+	// MaxStringLength is the maximum length of a string value in bytes.
+	// Longer strings are cut short and end in "…".
+	MaxStringLength int
+	// MaxElements is the maximum number of elements of a slice, array or map,
+	// and of attributes in a group.
+	MaxElements int
+	// MaxDepth is the maximum nesting of objects and arrays in a property.
+	// Values nested deeper are written as "!DEPTH".
+	MaxDepth int
+	// MaxProperties is the maximum number of top-level properties of an
+	// event. The ones added last are left out.
+	MaxProperties int
+}
+
+// limitsKey is the property listing what the limits cut from an event.
+const limitsKey = "_limits"
+
+const (
+	ellipsis    = "…"
+	depthMarker = "!DEPTH"
+)
+
+// limitReport records which properties were cut by the limits, by their
+// dotted path.
+type limitReport struct {
+	strings, elements, depth []string
+	properties               int // top-level properties left out
+}
+
+func (r *limitReport) empty() bool {
+	return len(r.strings) == 0 && len(r.elements) == 0 && len(r.depth) == 0 && r.properties == 0
+}
+
+// append appends the report as a JSON object.
+func (r *limitReport) append(buf []byte) []byte {
+	buf = append(buf, '{')
+	for _, l := range []struct {
+		key   string
+		paths []string
+	}{{"strings", r.strings}, {"elements", r.elements}, {"depth", r.depth}} {
+		if len(l.paths) == 0 {
+			continue
+		}
+		buf = append(buf, '"')
+		buf = append(buf, l.key...)
+		buf = append(buf, `":[`...)
+		for i, p := range l.paths {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendString(buf, p)
+		}
+		buf = append(buf, "],"...)
+	}
+	if r.properties > 0 {
+		buf = append(buf, `"properties":`...)
+		buf = strconv.AppendInt(buf, int64(r.properties), 10)
+		buf = append(buf, ',')
+	}
+	buf[len(buf)-1] = '}'
+	return buf
+}
+
+// push and pop keep track of the path of the value being written, which is
+// only needed with limits.
+func (enc *valueEncoder) push(key string) {
+	if enc.limited {
+		enc.path = append(enc.path, key)
+	}
+}
+
+func (enc *valueEncoder) pop() {
+	if enc.limited {
+		enc.path = enc.path[:len(enc.path)-1]
+	}
+}
+
+// note adds the path of the value being written to paths.
+func (enc *valueEncoder) note(paths *[]string) {
+	p := strings.Join(enc.path, ".")
+	if !slices.Contains(*paths, p) {
+		*paths = append(*paths, p)
+	}
+}
+
+// nest enters an object or array. It returns false if that would exceed
+// MaxDepth, in which case depthMarker should be written instead.
+func (enc *valueEncoder) nest() bool {
+	if enc.limits.MaxDepth > 0 && enc.depth >= enc.limits.MaxDepth {
+		enc.note(&enc.report.depth)
+		return false
+	}
+	enc.depth++
+	return true
+}
+
+func (enc *valueEncoder) unnest() {
+	enc.depth--
+}
+
+// elements returns how many of n elements may be written.
+func (enc *valueEncoder) elements(n int) int {
+	if max := enc.limits.MaxElements; max > 0 && n > max {
+		enc.note(&enc.report.elements)
+		return max
+	}
+	return n
+}
+
+// appendStringValue appends a string property value, cut to MaxStringLength.
+func (enc *valueEncoder) appendStringValue(buf []byte, s string) []byte {
+	return appendString(buf, enc.limitString(s))
+}
+
+// limitString cuts a string property value to MaxStringLength.
+func (enc *valueEncoder) limitString(s string) string {
+	if max := enc.limits.MaxStringLength; max > 0 && len(s) > max {
+		enc.note(&enc.report.strings)
+		if max > len(ellipsis) {
+			return truncateString(s, max-len(ellipsis)) + ellipsis
+		}
+		return truncateString(s, max)
+	}
+	return s
+}
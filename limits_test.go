@@ -0,0 +1,70 @@
+package slogseq
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits(t *testing.T) {
+	type inner struct{ Deep map[string]any }
+
+	handler := newQueueingHandler(WithLimits(Limits{
+		MaxStringLength: 10,
+		MaxElements:     3,
+		MaxDepth:        1,
+		MaxProperties:   5,
+	}))
+	defer handler.Close()
+
+	slog.New(handler).Info("limited",
+		"body", strings.Repeat("x", 100),
+		"short", "fits",
+		"items", []int{1, 2, 3, 4, 5},
+		slog.Group("req", "a", 1, "b", 2, "c", 3, "d", 4),
+		"nested", inner{Deep: map[string]any{"too": "deep"}},
+		"sixth", "left out",
+		"seventh", "left out too",
+	)
+
+	e := <-handler.workers[0].eventsCh
+	evt := decodeEntry(t, e)
+	assert.Equal(t, "limited", evt.Message)
+	assert.Equal(t, "xxxxxxx…", evt.Properties["body"])
+	assert.Equal(t, "fits", evt.Properties["short"])
+	assert.Equal(t, []any{float64(1), float64(2), float64(3)}, evt.Properties["items"])
+	assert.Equal(t, map[string]any{"a": float64(1), "b": float64(2), "c": float64(3)}, evt.Properties["req"])
+	assert.Equal(t, map[string]any{"Deep": "!DEPTH"}, evt.Properties["nested"])
+	assert.NotContains(t, evt.Properties, "sixth")
+	assert.NotContains(t, evt.Properties, "seventh")
+
+	assert.Equal(t, map[string]any{
+		"strings":    []any{"body"},
+		"elements":   []any{"items", "req"},
+		"depth":      []any{"nested.Deep"},
+		"properties": float64(2),
+	}, evt.Properties[limitsKey])
+	assert.True(t, strings.HasSuffix(string(e.line), `"_limits":{"strings":["body"],"elements":["items","req"],"depth":["nested.Deep"],"properties":2}}`+"\n"))
+}
+
+func TestLimits_NotAddedWhenNothingIsCut(t *testing.T) {
+	handler := newQueueingHandler(WithLimits(Limits{MaxStringLength: 10, MaxElements: 3, MaxDepth: 2, MaxProperties: 5}))
+	defer handler.Close()
+
+	slog.New(handler).Info("small", "a", "short", slog.Group("g", "b", []string{"x"}))
+
+	evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+	require.NotContains(t, evt.Properties, limitsKey)
+	assert.Equal(t, map[string]any{"b": []any{"x"}}, evt.Properties["g"])
+}
+
+func TestAppendStringValue_KeepsUTF8Valid(t *testing.T) {
+	enc := valueEncoder{limits: Limits{MaxStringLength: 6}, limited: true}
+
+	// "…" takes 3 bytes, leaving room for one "å" but not half of the second
+	assert.Equal(t, `"å…"`, string(enc.appendStringValue(nil, "ååååå")))
+	assert.Equal(t, []string{""}, enc.report.strings)
+}
@@ -0,0 +1,151 @@
+package slogseq
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// LogExporter sends the records of the OpenTelemetry Logs SDK to Seq through
+// a SeqHandler, so they share its workers and connection with slog and spans.
+// Use it with sdklog.NewBatchProcessor or sdklog.NewSimpleProcessor.
+type LogExporter struct {
+	Handler *SeqHandler
+
+	stopped atomic.Bool
+}
+
+var _ sdklog.Exporter = (*LogExporter)(nil)
+
+// NewLogExporter returns a LogExporter sending records through handler.
+func NewLogExporter(handler *SeqHandler) *LogExporter {
+	return &LogExporter{Handler: handler}
+}
+
+// Export converts records to CLEF events and queues them on the handler.
+func (e *LogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	if e.stopped.Load() {
+		return nil
+	}
+	// most records of a batch come from the same logger
+	var (
+		lastRes   resource.Resource
+		lastScope instrumentation.Scope
+		ra        map[string]any
+	)
+	for i := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r := &records[i]
+		res, scope := r.Resource(), r.InstrumentationScope()
+		if i == 0 || !res.Equal(&lastRes) || scope.Name != lastScope.Name || scope.Version != lastScope.Version {
+			lastRes, lastScope = res, scope
+			ra = resourceAttributes(&res, scope)
+		}
+		e.logRecord(r, ra)
+	}
+	return nil
+}
+
+// ForceFlush sends the records queued on the handler.
+func (e *LogExporter) ForceFlush(ctx context.Context) error {
+	if e.stopped.Load() {
+		return nil
+	}
+	return flushHandler(ctx, e.Handler)
+}
+
+// Shutdown stops exporting and flushes the handler, which stays open.
+func (e *LogExporter) Shutdown(ctx context.Context) error {
+	if e.stopped.Swap(true) {
+		return nil
+	}
+	return flushHandler(ctx, e.Handler)
+}
+
+// logRecord sends a record as a CLEF event: the body as the message, the
+// severity as the level and the attributes as properties. ra are its resource
+// attributes.
+func (e *LogExporter) logRecord(r *sdklog.Record, ra map[string]any) {
+	event := &CLEFEvent{
+		Timestamp:          r.Timestamp(),
+		Level:              e.level(r),
+		ResourceAttributes: ra,
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = r.ObservedTimestamp()
+	}
+	if tid, sid := r.TraceID(), r.SpanID(); tid.IsValid() && sid.IsValid() {
+		event.TraceID = tid.String()
+		event.SpanID = sid.String()
+	}
+
+	props := getPropTree()
+	defer putPropTree(props)
+	if body := r.Body(); body.Kind() == log.KindString {
+		event.Message = body.AsString()
+	} else if !body.Empty() {
+		props.set(rootNode, "body", logValue(body))
+	}
+	if name := r.EventName(); name != "" {
+		props.set(rootNode, "event.name", slog.StringValue(name))
+	}
+	r.WalkAttributes(func(kv log.KeyValue) bool {
+		props.set(rootNode, kv.Key, logValue(kv.Value))
+		return true
+	})
+
+	e.Handler.handleEvent(event, props)
+}
+
+// level maps the severity of a record onto a CLEF level. Severities are
+// numbered like slog levels offset by SeverityInfo1, as the OpenTelemetry slog
+// bridge does, so the handler's level mapper applies. Records without a
+// severity get their severity text, or Information.
+func (e *LogExporter) level(r *sdklog.Record) string {
+	if s := r.Severity(); s != log.SeverityUndefined {
+		return e.Handler.clefLevel(slog.Level(s - log.SeverityInfo1)).String()
+	}
+	if text := r.SeverityText(); text != "" {
+		return text
+	}
+	return CLEFLevelInformation.String()
+}
+
+// logValue converts a value of the OpenTelemetry Logs API to a slog.Value.
+func logValue(v log.Value) slog.Value {
+	switch v.Kind() {
+	case log.KindBool:
+		return slog.BoolValue(v.AsBool())
+	case log.KindFloat64:
+		return slog.Float64Value(v.AsFloat64())
+	case log.KindInt64:
+		return slog.Int64Value(v.AsInt64())
+	case log.KindString:
+		return slog.StringValue(v.AsString())
+	case log.KindBytes:
+		return slog.AnyValue(v.AsBytes())
+	case log.KindSlice:
+		vs := v.AsSlice()
+		elems := make([]any, len(vs))
+		for i, e := range vs {
+			elems[i] = logValue(e)
+		}
+		return slog.AnyValue(elems)
+	case log.KindMap:
+		kvs := v.AsMap()
+		attrs := make([]slog.Attr, len(kvs))
+		for i, kv := range kvs {
+			attrs[i] = slog.Attr{Key: kv.Key, Value: logValue(kv.Value)}
+		}
+		return slog.GroupValue(attrs...)
+	default:
+		return slog.AnyValue(nil)
+	}
+}
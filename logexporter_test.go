@@ -0,0 +1,123 @@
+package slogseq
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLogExporter_ConvertsRecords(t *testing.T) {
+	handler := newQueueingHandler()
+	defer handler.Close()
+	lp := sdklog.NewLoggerProvider(
+		sdklog.WithProcessor(sdklog.NewSimpleProcessor(NewLogExporter(handler))),
+		sdklog.WithResource(resource.NewSchemaless(attribute.String("service.name", "api"))),
+	)
+	defer func() { _ = lp.Shutdown(context.Background()) }()
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var r log.Record
+	r.SetTimestamp(ts)
+	r.SetSeverity(log.SeverityInfo2)
+	r.SetBody(log.StringValue("user logged in"))
+	r.AddAttributes(
+		log.String("user", "alice"),
+		log.Int("attempts", 2),
+		log.Map("http", log.String("method", "POST"), log.Bool("tls", true)),
+		log.Slice("roles", log.StringValue("admin"), log.StringValue("dev")),
+	)
+	lp.Logger("auth", log.WithInstrumentationVersion("1.0.0")).Emit(ctx, r)
+
+	evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+	assert.Equal(t, "user logged in", evt.Message)
+	assert.Equal(t, CLEFLevelInformation.String(), evt.Level)
+	assert.True(t, evt.Timestamp.Equal(ts))
+	assert.Equal(t, sc.TraceID().String(), evt.TraceID)
+	assert.Equal(t, sc.SpanID().String(), evt.SpanID)
+	assert.Equal(t, map[string]any{
+		"service": map[string]any{"name": "api"},
+		"otel":    map[string]any{"scope": map[string]any{"name": "auth", "version": "1.0.0"}},
+	}, evt.ResourceAttributes)
+	assert.Equal(t, map[string]any{
+		"user":     "alice",
+		"attempts": float64(2),
+		"http":     map[string]any{"method": "POST", "tls": true},
+		"roles":    []any{"admin", "dev"},
+	}, evt.Properties)
+}
+
+func TestLogExporter_Body(t *testing.T) {
+	handler := newQueueingHandler()
+	defer handler.Close()
+	exporter := NewLogExporter(handler)
+
+	var r sdklog.Record
+	r.SetObservedTimestamp(time.Now())
+	r.SetBody(log.MapValue(log.String("k", "v")))
+	r.SetEventName("login")
+	require.NoError(t, exporter.Export(context.Background(), []sdklog.Record{r}))
+
+	evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+	assert.Empty(t, evt.Message)
+	assert.False(t, evt.Timestamp.IsZero(), "falls back to the observed timestamp")
+	assert.Equal(t, map[string]any{"body": map[string]any{"k": "v"}, "event.name": "login"}, evt.Properties)
+}
+
+func TestLogExporter_Level(t *testing.T) {
+	exporter := NewLogExporter(&SeqHandler{})
+	for _, tt := range []struct {
+		severity log.Severity
+		text     string
+		expected string
+	}{
+		{log.SeverityTrace1, "", "Verbose"},
+		{log.SeverityDebug4, "", "Debug"},
+		{log.SeverityInfo1, "", "Information"},
+		{log.SeverityWarn2, "", "Warning"},
+		{log.SeverityError1, "", "Error"},
+		{log.SeverityFatal4, "", "Fatal"},
+		{log.SeverityUndefined, "notice", "notice"},
+		{log.SeverityUndefined, "", "Information"},
+	} {
+		var r sdklog.Record
+		r.SetSeverity(tt.severity)
+		r.SetSeverityText(tt.text)
+		assert.Equal(t, tt.expected, exporter.level(&r), "severity %v", tt.severity)
+	}
+
+	custom := NewLogExporter(&SeqHandler{levelMapper: func(l slog.Level) CLEFLevel { return CLEFLevelFatal }})
+	var r sdklog.Record
+	r.SetSeverity(log.SeverityInfo)
+	assert.Equal(t, "Fatal", custom.level(&r), "uses the level mapper of the handler")
+}
+
+func TestLogExporter_Shutdown(t *testing.T) {
+	handler := newQueueingHandler()
+	defer handler.Close()
+	exporter := NewLogExporter(handler)
+
+	require.NoError(t, exporter.Shutdown(context.Background()))
+	var r sdklog.Record
+	r.SetBody(log.StringValue("late"))
+	require.NoError(t, exporter.Export(context.Background(), []sdklog.Record{r}))
+	assert.Zero(t, len(handler.workers[0].eventsCh), "no exports after shutdown")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, NewLogExporter(handler).Export(ctx, []sdklog.Record{r}), context.Canceled)
+}
@@ -0,0 +1,155 @@
+package slogseq
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryBudget limits how many bytes of encoded events the handler holds in
+// memory, across the queues, batches and retry buffers of all workers. A nil
+// *memoryBudget has no limit.
+type memoryBudget struct {
+	limit int64
+
+	mu   sync.Mutex
+	used int64
+	// freed is closed and replaced whenever memory is released, to wake up
+	// anyone waiting for room.
+	freed chan struct{}
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit, freed: make(chan struct{})}
+}
+
+// size returns the size of an entry, or 0 without a budget.
+func (b *memoryBudget) size(e entry) int64 {
+	if b == nil {
+		return 0
+	}
+	return int64(len(e.line) + e.otlp.size())
+}
+
+// sizeOf returns the size of entries, or 0 without a budget.
+func (b *memoryBudget) sizeOf(entries []entry) int64 {
+	if b == nil {
+		return 0
+	}
+	var n int64
+	for _, e := range entries {
+		n += int64(len(e.line) + e.otlp.size())
+	}
+	return n
+}
+
+// reserve takes n bytes from the budget if they fit. An event larger than the
+// whole budget is let through when nothing else is held, so it can't get
+// stuck forever.
+func (b *memoryBudget) reserve(n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used > 0 && b.used+n > b.limit {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// take takes n bytes from the budget, even if that goes over the limit.
+func (b *memoryBudget) take(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used += n
+}
+
+func (b *memoryBudget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used = max(b.used-n, 0)
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+// waitFreed returns a channel that is closed the next time memory is released.
+func (b *memoryBudget) waitFreed() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.freed
+}
+
+func (b *memoryBudget) usage() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// reserveMemory takes room for an entry of size bytes from the memory budget,
+// applying the overflow policy if the budget is used up. It returns false if
+// the event was dropped or spilled to disk instead. Priority events may go
+// over the budget, so a flood of other events can't crowd them out.
+func (h *SeqHandler) reserveMemory(w *worker, ch chan entry, e entry, size int64) bool {
+	if h.memory.reserve(size) {
+		return true
+	}
+	if w.priorityCh != nil && ch == w.priorityCh {
+		h.memory.take(size)
+		return true
+	}
+
+	switch h.overflowPolicy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case old := <-ch:
+				h.memory.release(h.memory.size(old))
+				h.recordDropped(w, dropQueueFull, 1)
+			default:
+				// nothing left to make room with
+				h.recordDropped(w, dropQueueFull, 1)
+				return false
+			}
+			if h.memory.reserve(size) {
+				return true
+			}
+		}
+	case OverflowBlock:
+		var timeout <-chan time.Time
+		if h.blockTimeout > 0 {
+			timer := time.NewTimer(h.blockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		for {
+			freed := h.memory.waitFreed()
+			if h.memory.reserve(size) {
+				return true
+			}
+			select {
+			case <-freed:
+			case <-timeout:
+				h.recordDropped(w, dropQueueFull, 1)
+				return false
+			}
+		}
+	case OverflowSpill:
+		if !h.spoolEvents(w, []entry{e}) {
+			h.recordDropped(w, dropQueueFull, 1)
+		}
+		return false
+	default:
+		h.recordDropped(w, dropQueueFull, 1)
+		return false
+	}
+}
@@ -0,0 +1,145 @@
+package slogseq
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBudget_SizeIsEncodedLength(t *testing.T) {
+	e := testEntries(t, bigEvent("a"))[0]
+
+	assert.Equal(t, int64(len(e.line)), newMemoryBudget(1).size(e))
+	assert.Equal(t, 2*int64(len(e.line)), newMemoryBudget(1).sizeOf([]entry{e, e}))
+}
+
+func TestMemoryBudget_Reserve(t *testing.T) {
+	b := newMemoryBudget(100)
+
+	assert.True(t, b.reserve(60))
+	assert.False(t, b.reserve(60))
+	assert.True(t, b.reserve(40))
+	b.release(100)
+	assert.Zero(t, b.usage())
+
+	assert.True(t, b.reserve(1000), "an event larger than the budget gets through on its own")
+	assert.False(t, b.reserve(1))
+
+	var nilBudget *memoryBudget
+	assert.True(t, nilBudget.reserve(1<<40))
+	assert.Zero(t, nilBudget.size(testEntries(t, CLEFEvent{Message: "free"})[0]))
+}
+
+func bigEvent(msg string) CLEFEvent {
+	return CLEFEvent{Message: msg, Level: "Information", Properties: map[string]any{"body": strings.Repeat("x", 1000)}}
+}
+
+// bigEventSize returns the encoded size of a bigEvent with a one letter message.
+func bigEventSize(t *testing.T) int64 {
+	return int64(len(testEntries(t, bigEvent("a"))[0].line))
+}
+
+func TestMemoryLimit_DropNewest(t *testing.T) {
+	handler := &SeqHandler{
+		memory:  newMemoryBudget(2500),
+		workers: []worker{{eventsCh: make(chan entry, 100)}},
+	}
+
+	for _, m := range []string{"a", "b", "c"} {
+		handler.HandleCLEFEvent(bigEvent(m))
+	}
+
+	stats := handler.Stats()
+	assert.Equal(t, uint64(2), stats.Workers[0].Enqueued)
+	assert.Equal(t, uint64(1), stats.Workers[0].DroppedQueueFull)
+	assert.Equal(t, 2*bigEventSize(t), stats.MemoryUsed)
+}
+
+func TestMemoryLimit_DropOldest(t *testing.T) {
+	handler := &SeqHandler{
+		memory:         newMemoryBudget(2500),
+		overflowPolicy: OverflowDropOldest,
+		workers:        []worker{{eventsCh: make(chan entry, 100)}},
+	}
+
+	for _, m := range []string{"a", "b", "c"} {
+		handler.HandleCLEFEvent(bigEvent(m))
+	}
+
+	assert.Equal(t, "b", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	assert.Equal(t, "c", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	assert.Equal(t, uint64(1), handler.Stats().Workers[0].DroppedQueueFull)
+}
+
+func TestMemoryLimit_BlockUntilReleased(t *testing.T) {
+	handler := &SeqHandler{
+		memory:         newMemoryBudget(1500),
+		overflowPolicy: OverflowBlock,
+		blockTimeout:   time.Second,
+		workers:        []worker{{eventsCh: make(chan entry, 100)}},
+	}
+	handler.HandleCLEFEvent(bigEvent("a"))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		e := <-handler.workers[0].eventsCh
+		handler.memory.release(handler.memory.size(e))
+	}()
+	handler.HandleCLEFEvent(bigEvent("b"))
+
+	assert.Equal(t, "b", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	assert.Zero(t, handler.Stats().Workers[0].DroppedQueueFull)
+}
+
+func TestMemoryLimit_ReleasedOnceSent(t *testing.T) {
+	seq := newFakeSeq(503)
+	_, handler := NewLogger("http://example.com",
+		WithHTTPClient(seq.client()),
+		WithFlushInterval(time.Hour),
+		WithMemoryLimit(1<<20),
+		WithRetryPolicy(RetryPolicy{BaseDelay: time.Millisecond}),
+	)
+	defer handler.Close()
+
+	handler.HandleCLEFEvent(bigEvent("a"))
+	handler.HandleCLEFEvent(bigEvent("b"))
+	assert.Equal(t, 2*bigEventSize(t), handler.Stats().MemoryUsed)
+
+	// kept in the retry buffer while Seq is down
+	require.Error(t, handler.Flush(context.Background()))
+	assert.Equal(t, 2*bigEventSize(t), handler.Stats().MemoryUsed)
+
+	seq.setStatus(201)
+	require.NoError(t, handler.Flush(context.Background()))
+	assert.Zero(t, handler.Stats().MemoryUsed)
+}
+
+func TestMemoryLimit_PriorityEventsGoOverBudget(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDropNewest, OverflowDropOldest} {
+		handler := &SeqHandler{
+			memory:         newMemoryBudget(10000),
+			overflowPolicy: policy,
+			priorityLevel:  slog.LevelWarn,
+			workers: []worker{{
+				eventsCh:   make(chan entry, 2000),
+				priorityCh: make(chan entry, 2),
+			}},
+		}
+
+		for range 1000 {
+			handler.HandleCLEFEvent(CLEFEvent{Message: "flood", Level: "Debug"})
+		}
+		handler.HandleCLEFEvent(CLEFEvent{Message: "first", Level: "Error"})
+		handler.HandleCLEFEvent(CLEFEvent{Message: "second", Level: "Error"})
+
+		require.Len(t, handler.workers[0].priorityCh, 2, "policy %v", policy)
+		assert.Equal(t, "first", decodeEntry(t, <-handler.workers[0].priorityCh).Message)
+		assert.Equal(t, "second", decodeEntry(t, <-handler.workers[0].priorityCh).Message)
+		assert.Greater(t, handler.Stats().MemoryUsed, int64(10000))
+	}
+}
@@ -0,0 +1,113 @@
+package slogseq
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const meterName = "github.com/sokkalf/slog-seq"
+
+// instruments reports the health of the handler's pipeline through
+// OpenTelemetry metrics. A nil *instruments records nothing.
+type instruments struct {
+	batchSize       metric.Int64Histogram
+	requestDuration metric.Float64Histogram
+	bytesSent       metric.Int64Counter
+	eventsDropped   metric.Int64Counter
+	registration    metric.Registration
+}
+
+func newInstruments(mp metric.MeterProvider, h *SeqHandler) (*instruments, error) {
+	meter := mp.Meter(meterName)
+	m := &instruments{}
+
+	var err error
+	if m.batchSize, err = meter.Int64Histogram("slogseq.batch.size",
+		metric.WithDescription("Number of events per request sent to Seq."),
+		metric.WithUnit("{event}"),
+	); err != nil {
+		return nil, err
+	}
+	if m.requestDuration, err = meter.Float64Histogram("slogseq.request.duration",
+		metric.WithDescription("Duration of requests to Seq."),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+	if m.bytesSent, err = meter.Int64Counter("slogseq.sent.bytes",
+		metric.WithDescription("Bytes of request bodies accepted by Seq."),
+		metric.WithUnit("By"),
+	); err != nil {
+		return nil, err
+	}
+	if m.eventsDropped, err = meter.Int64Counter("slogseq.events.dropped",
+		metric.WithDescription("Events dropped before reaching Seq, by reason."),
+		metric.WithUnit("{event}"),
+	); err != nil {
+		return nil, err
+	}
+
+	queueDepth, err := meter.Int64ObservableGauge("slogseq.queue.depth",
+		metric.WithDescription("Events waiting in a worker's queue."),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	retryBuffer, err := meter.Int64ObservableGauge("slogseq.retry_buffer.size",
+		metric.WithDescription("Events waiting in a worker's retry buffer."),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	m.registration, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for i := range h.workers {
+			w := &h.workers[i]
+			attrs := metric.WithAttributes(attribute.Int("worker", i))
+			o.ObserveInt64(queueDepth, int64(w.queueLength()), attrs)
+			o.ObserveInt64(retryBuffer, w.stats.retryBufferSize.Load(), attrs)
+		}
+		return nil
+	}, queueDepth, retryBuffer)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// recordRequest records a request to Seq. statusCode is 0 if no response was received.
+func (m *instruments) recordRequest(ctx context.Context, events, bodyBytes int, statusCode int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	m.requestDuration.Record(ctx, elapsed.Seconds(),
+		metric.WithAttributes(attribute.String("status", status)))
+	if statusCode >= 200 && statusCode <= 299 {
+		m.batchSize.Record(ctx, int64(events))
+		m.bytesSent.Add(ctx, int64(bodyBytes))
+	}
+}
+
+func (m *instruments) recordDropped(reason dropReason, n int) {
+	if m == nil {
+		return
+	}
+	m.eventsDropped.Add(context.Background(), int64(n),
+		metric.WithAttributes(attribute.String("reason", string(reason))))
+}
+
+func (m *instruments) unregister() {
+	if m == nil || m.registration == nil {
+		return
+	}
+	_ = m.registration.Unregister()
+}
@@ -0,0 +1,85 @@
+package slogseq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func collectMetrics(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	out := make(map[string]metricdata.Metrics)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			out[m.Name] = m
+		}
+	}
+	return out
+}
+
+func TestMeterProvider(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	seq := newFakeSeq(201)
+	logger, handler := NewLogger("http://example.com",
+		WithHTTPClient(seq.client()),
+		WithFlushInterval(time.Hour),
+		WithWorkers(2),
+		WithMeterProvider(mp),
+	)
+	defer handler.Close()
+
+	logger.Info("one")
+	logger.Info("two")
+	logger.Info("three")
+	require.NoError(t, handler.Flush(context.Background()))
+	handler.recordDropped(&handler.workers[0], dropQueueFull, 2)
+
+	metrics := collectMetrics(t, reader)
+
+	batches := metrics["slogseq.batch.size"].Data.(metricdata.Histogram[int64])
+	var events int64
+	for _, dp := range batches.DataPoints {
+		events += dp.Sum
+	}
+	assert.Equal(t, int64(3), events)
+
+	bytesSent := metrics["slogseq.sent.bytes"].Data.(metricdata.Sum[int64])
+	require.Len(t, bytesSent.DataPoints, 1)
+	assert.Equal(t, seq.bytesSent(), bytesSent.DataPoints[0].Value)
+
+	durations := metrics["slogseq.request.duration"].Data.(metricdata.Histogram[float64])
+	require.NotEmpty(t, durations.DataPoints)
+	status, _ := durations.DataPoints[0].Attributes.Value("status")
+	assert.Equal(t, "201", status.AsString())
+
+	dropped := metrics["slogseq.events.dropped"].Data.(metricdata.Sum[int64])
+	require.Len(t, dropped.DataPoints, 1)
+	assert.Equal(t, int64(2), dropped.DataPoints[0].Value)
+	reason, _ := dropped.DataPoints[0].Attributes.Value("reason")
+	assert.Equal(t, "queue_full", reason.AsString())
+
+	depth := metrics["slogseq.queue.depth"].Data.(metricdata.Gauge[int64])
+	require.Len(t, depth.DataPoints, 2, "one data point per worker")
+	for _, dp := range depth.DataPoints {
+		_, ok := dp.Attributes.Value(attribute.Key("worker"))
+		assert.True(t, ok)
+	}
+}
+
+func TestMeterProvider_NotConfigured(t *testing.T) {
+	var m *instruments
+	// a nil *instruments must be safe to use
+	m.recordRequest(context.Background(), 1, 1, 200, time.Second)
+	m.recordDropped(dropPurged, 1)
+	m.unregister()
+}
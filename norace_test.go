@@ -0,0 +1,5 @@
+//go:build !race
+
+package slogseq
+
+const raceEnabled = false
@@ -2,13 +2,57 @@ package slogseq
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/sdk/trace"
 	tr "go.opentelemetry.io/otel/trace"
 )
 
+// SpanEventMode decides how the events recorded on a span are sent to Seq.
+type SpanEventMode int
+
+const (
+	// SpanEventsSeparate sends every span event as a log event of its own,
+	// linked to its span. This is the default.
+	SpanEventsSeparate SpanEventMode = iota
+	// SpanEventsNested sends span events only as the "events" property of
+	// the span itself.
+	SpanEventsNested
+)
+
+// LoggingSpanProcessor sends ended spans to Seq, where they show up in the
+// trace view, along with the events recorded on them. It can be used as a
+// trace.SpanProcessor or as a trace.SpanExporter.
 type LoggingSpanProcessor struct {
 	Handler *SeqHandler
+
+	spanEvents SpanEventMode
+	stopped    atomic.Bool
+}
+
+// SpanProcessorOption is an option for NewLoggingSpanProcessor.
+type SpanProcessorOption func(*LoggingSpanProcessor)
+
+// WithSpanEvents sets how span events are sent. Default is SpanEventsSeparate.
+func WithSpanEvents(mode SpanEventMode) SpanProcessorOption {
+	return func(p *LoggingSpanProcessor) {
+		p.spanEvents = mode
+	}
+}
+
+// NewLoggingSpanProcessor returns a LoggingSpanProcessor sending spans
+// through handler.
+func NewLoggingSpanProcessor(handler *SeqHandler, opts ...SpanProcessorOption) *LoggingSpanProcessor {
+	p := &LoggingSpanProcessor{Handler: handler}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 func (p *LoggingSpanProcessor) OnStart(ctx context.Context, s trace.ReadWriteSpan) {
@@ -16,60 +60,156 @@ func (p *LoggingSpanProcessor) OnStart(ctx context.Context, s trace.ReadWriteSpa
 }
 
 func (p *LoggingSpanProcessor) OnEnd(s trace.ReadOnlySpan) {
-	events := s.Events()
-	for _, e := range events {
-		p.logOtelEventAsCLEF(s, e)
+	if p.stopped.Load() {
+		return
 	}
+	p.logSpan(s)
 }
 
+// ForceFlush sends the spans queued on the handler.
 func (p *LoggingSpanProcessor) ForceFlush(ctx context.Context) error {
-	return nil
+	if p.stopped.Load() {
+		return nil
+	}
+	return flushHandler(ctx, p.Handler)
 }
 
+// Shutdown stops sending spans and flushes the handler, which stays open.
 func (p *LoggingSpanProcessor) Shutdown(ctx context.Context) error {
-	return nil
+	if p.stopped.Swap(true) {
+		return nil
+	}
+	return flushHandler(ctx, p.Handler)
 }
 
 func (p *LoggingSpanProcessor) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	if p.stopped.Load() {
+		return nil
+	}
 	for _, s := range spans {
-		for _, e := range s.Events() {
-			p.logOtelEventAsCLEF(s, e)
+		if err := ctx.Err(); err != nil {
+			return err
 		}
+		p.logSpan(s)
+	}
+	return nil
+}
+
+// flushHandler flushes a handler on behalf of an OpenTelemetry provider, for
+// the ForceFlush and Shutdown of LoggingSpanProcessor and LogExporter. Neither
+// shuts the handler down, as it's usually shared with slog and outlives the
+// provider; that is up to SeqHandler.Shutdown. If the handler was shut down
+// already, it delivered what it could back then.
+func flushHandler(ctx context.Context, h *SeqHandler) error {
+	if err := h.Flush(ctx); !errors.Is(err, ErrClosed) {
+		return err
 	}
 	return nil
 }
 
-func (p *LoggingSpanProcessor) logOtelEventAsCLEF(span trace.ReadOnlySpan, e trace.Event) {
+// logSpan sends a span and, unless they are nested in it, its events.
+func (p *LoggingSpanProcessor) logSpan(s trace.ReadOnlySpan) {
+	ra := resourceAttributes(s.Resource(), s.InstrumentationScope())
+	if p.spanEvents == SpanEventsSeparate {
+		for _, e := range s.Events() {
+			p.logOtelEventAsCLEF(s, e, ra)
+		}
+	}
+	p.logSpanAsCLEF(s, ra)
+}
+
+// logSpanAsCLEF sends a span as a CLEF span: the name as the message, the end
+// as the timestamp, the start as @st, and the span's attributes and status
+// as properties. ra are its resource attributes.
+func (p *LoggingSpanProcessor) logSpanAsCLEF(span trace.ReadOnlySpan, ra map[string]any) {
 	sc := span.SpanContext()
 	if !sc.IsValid() {
 		return
 	}
 
-	spanKind := tr.ValidateSpanKind(span.SpanKind()).String()
 	event := &CLEFEvent{
-		Timestamp:          e.Time,
-		Message:            e.Name,
+		Timestamp:          span.EndTime(),
+		Level:              CLEFLevelInformation.String(),
+		Message:            span.Name(),
 		TraceID:            sc.TraceID().String(),
 		SpanID:             sc.SpanID().String(),
 		SpanStart:          span.StartTime(),
-		SpanKind:           spanKind,
-		ResourceAttributes: map[string]any{"service": map[string]any{"name": span.Name()}},
-		Properties:         make(map[string]any),
+		SpanKind:           tr.ValidateSpanKind(span.SpanKind()).String(),
+		ResourceAttributes: ra,
 	}
-
 	if parent := span.Parent(); parent.IsValid() {
 		event.ParentSpanID = parent.SpanID().String()
 	}
 
+	props := getPropTree()
+	defer putPropTree(props)
+	for _, attr := range span.Attributes() {
+		props.set(rootNode, string(attr.Key), slog.AnyValue(attr.Value.AsInterface()))
+	}
+
+	status := span.Status()
+	if status.Code == codes.Error {
+		event.Level = CLEFLevelError.String()
+	}
+	if status.Code != codes.Unset {
+		// named as the OpenTelemetry conventions for non-OTLP exporters do
+		props.set(rootNode, "otel.status_code", slog.StringValue(strings.ToUpper(status.Code.String())))
+	}
+	if status.Description != "" {
+		props.set(rootNode, "otel.status_description", slog.StringValue(status.Description))
+	}
+
+	if events := span.Events(); p.spanEvents == SpanEventsNested && len(events) > 0 {
+		nested := make([]any, len(events))
+		for i, e := range events {
+			nested[i] = slog.GroupValue(
+				slog.String("name", e.Name),
+				slog.Time("time", e.Time),
+				slog.Attr{Key: "attributes", Value: slog.GroupValue(otelAttrs(e.Attributes)...)},
+			)
+		}
+		props.set(rootNode, "events", slog.AnyValue(nested))
+	}
+
+	p.Handler.handleEvent(event, props)
+}
+
+// logOtelEventAsCLEF sends a span event as a log event. It is linked to its
+// span by @tr and @sp, but has no @st, so Seq doesn't take it for a span of
+// its own.
+func (p *LoggingSpanProcessor) logOtelEventAsCLEF(span trace.ReadOnlySpan, e trace.Event, ra map[string]any) {
+	sc := span.SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+
+	event := &CLEFEvent{
+		Timestamp:          e.Time,
+		Message:            e.Name,
+		TraceID:            sc.TraceID().String(),
+		SpanID:             sc.SpanID().String(),
+		ResourceAttributes: ra,
+	}
+
+	props := getPropTree()
+	defer putPropTree(props)
 	for _, attr := range e.Attributes {
 		k := string(attr.Key)
 		v := attr.Value.AsInterface()
-		event.Properties[k] = v
+		props.set(rootNode, k, slog.AnyValue(v))
 		if k == "exception.message" {
 			event.Level = CLEFLevelError.String()
 			event.Message = v.(string)
 		}
 	}
 
-	p.Handler.HandleCLEFEvent(*event)
+	p.Handler.handleEvent(event, props)
+}
+
+func otelAttrs(kvs []attribute.KeyValue) []slog.Attr {
+	attrs := make([]slog.Attr, len(kvs))
+	for i, kv := range kvs {
+		attrs[i] = slog.Any(string(kv.Key), kv.Value.AsInterface())
+	}
+	return attrs
 }
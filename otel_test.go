@@ -2,10 +2,15 @@ package slogseq
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -34,7 +39,8 @@ func TestOnEnd_WithException(t *testing.T) {
 	var evt CLEFEvent
 
 	select {
-	case evt = <-handler.workers[0].eventsCh:
+	case e := <-handler.workers[0].eventsCh:
+		evt = decodeEntry(t, e)
 	case <-time.After(1000 * time.Millisecond):
 		t.Fatal("timed out waiting for event")
 	}
@@ -50,7 +56,267 @@ func TestOnEnd_WithException(t *testing.T) {
 	// Check that additional properties (like code) are present.
 	if code, ok := evt.Properties["code"]; !ok {
 		t.Errorf("expected property 'code' to be set")
-	} else if code.(int64) != 500 {
+	} else if code != float64(500) {
 		t.Errorf("expected code 500, got %v", code)
 	}
 }
+
+func TestOnEnd_ReservedKeysEscaped(t *testing.T) {
+	handler := &SeqHandler{noFlush: true, workerCount: 1}
+	handler.start()
+	processor := &LoggingSpanProcessor{Handler: handler}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	_, span := tp.Tracer("test-tracer").Start(context.Background(), "testSpan")
+	span.AddEvent("event", trace.WithAttributes(attribute.String("@l", "not a level")))
+	span.End()
+
+	var evt CLEFEvent
+	select {
+	case e := <-handler.workers[0].eventsCh:
+		evt = decodeEntry(t, e)
+	case <-time.After(1000 * time.Millisecond):
+		t.Fatal("timed out waiting for event")
+	}
+
+	if evt.Properties["@@l"] != "not a level" {
+		t.Errorf("expected @l to be escaped to @@l, got %v", evt.Properties)
+	}
+}
+
+func TestOnEnd_KeepsAttributeOrder(t *testing.T) {
+	handler := &SeqHandler{noFlush: true, workerCount: 1}
+	handler.start()
+	processor := &LoggingSpanProcessor{Handler: handler}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	_, span := tp.Tracer("test-tracer").Start(context.Background(), "testSpan")
+	span.AddEvent("event", trace.WithAttributes(
+		attribute.String("zebra", "z"),
+		attribute.Int("apple", 1),
+		attribute.Bool("mango", true),
+	))
+	span.End()
+
+	select {
+	case e := <-handler.workers[0].eventsCh:
+		if !strings.HasSuffix(string(e.line), `"zebra":"z","apple":1,"mango":true}`+"\n") {
+			t.Errorf("expected properties in attribute order, got %s", e.line)
+		}
+	case <-time.After(1000 * time.Millisecond):
+		t.Fatal("timed out waiting for event")
+	}
+}
+
+func TestOnEnd_ExportsSpan(t *testing.T) {
+	handler := &SeqHandler{noFlush: true, workerCount: 1}
+	handler.start()
+	processor := NewLoggingSpanProcessor(handler)
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+	tracer := tp.Tracer("test-tracer")
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, span := tracer.Start(ctx, "GET /items", trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("http.method", "GET"), attribute.Int("http.status_code", 500)))
+	span.SetStatus(codes.Error, "internal error")
+	span.End()
+
+	evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+	ro := span.(sdktrace.ReadOnlySpan)
+	if evt.Message != "GET /items" {
+		t.Errorf("expected the span name as message, got %q", evt.Message)
+	}
+	if !evt.SpanStart.Equal(ro.StartTime()) || !evt.Timestamp.Equal(ro.EndTime()) {
+		t.Errorf("expected @st and @t to be the start and end of the span, got %v and %v", evt.SpanStart, evt.Timestamp)
+	}
+	if evt.SpanKind != "server" {
+		t.Errorf("expected span kind server, got %q", evt.SpanKind)
+	}
+	if evt.ParentSpanID != parent.SpanContext().SpanID().String() {
+		t.Errorf("expected parent span %s, got %q", parent.SpanContext().SpanID(), evt.ParentSpanID)
+	}
+	if evt.Level != CLEFLevelError.String() {
+		t.Errorf("expected an errored span to be logged as Error, got %q", evt.Level)
+	}
+	expected := map[string]any{
+		"http.method":             "GET",
+		"http.status_code":        float64(500),
+		"otel.status_code":        "ERROR",
+		"otel.status_description": "internal error",
+	}
+	if diff := cmp.Diff(expected, evt.Properties); diff != "" {
+		t.Errorf("properties differ: (-want +got)\n%s", diff)
+	}
+}
+
+func TestOnEnd_NestedSpanEvents(t *testing.T) {
+	handler := &SeqHandler{noFlush: true, workerCount: 1}
+	handler.start()
+	processor := NewLoggingSpanProcessor(handler, WithSpanEvents(SpanEventsNested))
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	_, span := tp.Tracer("test-tracer").Start(context.Background(), "work")
+	span.AddEvent("step", trace.WithAttributes(attribute.Int("n", 1)))
+	span.End()
+
+	evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+	if evt.Message != "work" || evt.SpanStart.IsZero() {
+		t.Fatalf("expected the span itself, got %+v", evt)
+	}
+	events, _ := evt.Properties["events"].([]any)
+	if len(events) != 1 {
+		t.Fatalf("expected one nested event, got %v", evt.Properties["events"])
+	}
+	step := events[0].(map[string]any)
+	if step["name"] != "step" || step["attributes"].(map[string]any)["n"] != float64(1) {
+		t.Errorf("unexpected nested event %v", step)
+	}
+	if n := len(handler.workers[0].eventsCh); n != 0 {
+		t.Errorf("expected no separate events, got %d", n)
+	}
+}
+
+func TestOnEnd_SeparateSpanEventsArentSpans(t *testing.T) {
+	handler := &SeqHandler{noFlush: true, workerCount: 1}
+	handler.start()
+	processor := NewLoggingSpanProcessor(handler)
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	_, span := tp.Tracer("test-tracer").Start(context.Background(), "work")
+	span.AddEvent("step")
+	span.End()
+
+	step := decodeEntry(t, <-handler.workers[0].eventsCh)
+	work := decodeEntry(t, <-handler.workers[0].eventsCh)
+	if step.Message != "step" || !step.SpanStart.IsZero() {
+		t.Errorf("expected the event without @st first, got %+v", step)
+	}
+	if step.SpanID != work.SpanID || step.TraceID != work.TraceID {
+		t.Error("expected the event to be linked to its span")
+	}
+	if work.Message != "work" || work.SpanStart.IsZero() {
+		t.Errorf("expected the span after its events, got %+v", work)
+	}
+}
+
+func TestOnEnd_ResourceAttributes(t *testing.T) {
+	handler := &SeqHandler{noFlush: true, workerCount: 1}
+	handler.start()
+	processor := NewLoggingSpanProcessor(handler)
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "api"))),
+	)
+	defer func() { _ = tp.Shutdown(context.Background()) }()
+
+	_, span := tp.Tracer("test-tracer", trace.WithInstrumentationVersion("1.0.0")).Start(context.Background(), "GET /items")
+	span.AddEvent("step")
+	span.End()
+
+	expected := map[string]any{
+		"service": map[string]any{"name": "api"},
+		"otel":    map[string]any{"scope": map[string]any{"name": "test-tracer", "version": "1.0.0"}},
+	}
+	for range 2 { // the event, then the span
+		evt := decodeEntry(t, <-handler.workers[0].eventsCh)
+		if diff := cmp.Diff(expected, evt.ResourceAttributes); diff != "" {
+			t.Errorf("@ra of %q differs: (-want +got)\n%s", evt.Message, diff)
+		}
+	}
+}
+
+// TestLoggingSpanProcessor_ShutdownDeliversSpans checks that shutting down the
+// tracer provider is enough to get the spans to Seq.
+func TestLoggingSpanProcessor_ShutdownDeliversSpans(t *testing.T) {
+	seq := newFakeSeq(201)
+	logger, handler := NewLogger("http://fake",
+		WithHTTPClient(seq.client()),
+		WithBatchSize(100),
+		WithFlushInterval(time.Hour),
+	)
+	defer handler.Close()
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(NewLoggingSpanProcessor(handler)))
+	_, span := tp.Tracer("test-tracer").Start(context.Background(), "work")
+	span.End()
+
+	if err := tp.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+	delivered := strings.Contains(strings.Join(seq.bodies(), ""), `"@m":"work"`)
+	if !delivered {
+		t.Error("expected the span to be delivered by Shutdown")
+	}
+
+	// the handler is still open for slog
+	logger.Info("after")
+	if err := handler.Flush(context.Background()); err != nil {
+		t.Errorf("expected the handler to still work, got %v", err)
+	}
+}
+
+// TestLoggingSpanProcessor_ShutdownReportsUndelivered checks that spans Seq
+// doesn't accept are reported by Shutdown.
+func TestLoggingSpanProcessor_ShutdownReportsUndelivered(t *testing.T) {
+	_, handler := NewLogger("http://fake",
+		WithHTTPClient(newFakeSeq(503).client()),
+		WithBatchSize(100),
+		WithFlushInterval(time.Hour),
+	)
+	defer func() { _ = handler.Shutdown(canceledContext()) }()
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(NewLoggingSpanProcessor(handler)))
+	_, span := tp.Tracer("test-tracer").Start(context.Background(), "work")
+	span.End()
+
+	err := tp.Shutdown(context.Background())
+	var derr *DeliveryError
+	if !errors.As(err, &derr) {
+		t.Fatalf("expected a DeliveryError, got %v", err)
+	}
+	if derr.Undelivered != 1 {
+		t.Errorf("expected 1 undelivered event, got %d", derr.Undelivered)
+	}
+}
+
+// TestLoggingSpanProcessor_ForceFlushDeadline checks that ForceFlush gives up
+// when the context is done.
+func TestLoggingSpanProcessor_ForceFlushDeadline(t *testing.T) {
+	_, handler := NewLogger("http://fake",
+		WithHTTPClient(newFakeSeq(201).block().client()),
+		WithFlushInterval(time.Hour),
+	)
+	defer func() { _ = handler.Shutdown(canceledContext()) }()
+	processor := NewLoggingSpanProcessor(handler)
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(processor))
+	_, span := tp.Tracer("test-tracer").Start(context.Background(), "stuck")
+	span.End()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := processor.ForceFlush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ForceFlush took %v, expected it to respect the deadline", elapsed)
+	}
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -0,0 +1,569 @@
+package slogseq
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Protocol is the format in which events are sent to Seq.
+type Protocol int
+
+const (
+	// ProtocolCLEF sends events as newline-delimited CLEF to the URL given to
+	// NewLogger. This is the default.
+	ProtocolCLEF Protocol = iota
+	// ProtocolOTLP sends events as OTLP/HTTP protobuf, which newer versions of
+	// Seq ingest natively. The URL given to NewLogger is then the OTLP
+	// endpoint of Seq, such as http://seq:5341/ingest/otlp; log events are
+	// sent to /v1/logs and spans to /v1/traces under it.
+	ProtocolOTLP
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolOTLP:
+		return "otlp"
+	default:
+		return "clef"
+	}
+}
+
+const (
+	otlpLogsPath   = "/v1/logs"
+	otlpTracesPath = "/v1/traces"
+)
+
+// Events are queued, retried and spooled as CLEF lines with either protocol.
+// With OTLP, each entry also gets its OpenTelemetry log record or span when it
+// is encoded, from the same event and properties as the line, so property
+// values keep their Go types and nothing is decoded to send or retry it.
+// Lines replayed from the spool are decoded again instead. A batch holds
+// either log events or spans, as they go to different endpoints.
+
+// otlpRecord is an event converted for OTLP: a log record or a span.
+type otlpRecord struct {
+	resource string         // ra as JSON, records with the same resource are sent together
+	ra       map[string]any // the resource attributes
+	log      *logspb.LogRecord
+	span     *tracepb.Span
+}
+
+// size estimates the memory held by r, for the memory budget.
+func (r *otlpRecord) size() int {
+	switch {
+	case r == nil:
+		return 0
+	case r.span != nil:
+		return len(r.resource) + proto.Size(r.span)
+	default:
+		return len(r.resource) + proto.Size(r.log)
+	}
+}
+
+// otlpFields are the CLEF fields an event is converted from.
+type otlpFields struct {
+	time, spanStart                         time.Time
+	level, message, template, exception     string
+	traceID, spanID, parentSpanID, spanKind string
+}
+
+// set sets a field from its CLEF name and the value written for it.
+func (f *otlpFields) set(key, value string) {
+	switch key {
+	case "@t":
+		f.time, _ = time.Parse(time.RFC3339Nano, value)
+	case "@st":
+		f.spanStart, _ = time.Parse(time.RFC3339Nano, value)
+	case "@l":
+		f.level = value
+	case "@m":
+		f.message = value
+	case "@mt":
+		f.template = value
+	case "@x":
+		f.exception = value
+	case "@tr":
+		f.traceID = value
+	case "@sp":
+		f.spanID = value
+	case "@ps":
+		f.parentSpanID = value
+	case "@sk":
+		f.spanKind = value
+	}
+}
+
+// convertOTLP converts an event as it is encoded, with props as its
+// properties. The limits and the reserved key policy apply as they do to the
+// CLEF line, and groups become dotted keys as OpenTelemetry names attributes:
+// the method attribute of an http group becomes http.method.
+func (h *SeqHandler) convertOTLP(e *CLEFEvent, props *propTree) *otlpRecord {
+	enc := valueEncoder{converters: h.valueConverters, limits: h.limits, limited: h.limits != Limits{}}
+	f := otlpFields{
+		time:         e.Timestamp,
+		spanStart:    e.SpanStart,
+		level:        e.Level,
+		message:      e.Message,
+		template:     e.MessageTemplate,
+		exception:    e.Exception,
+		traceID:      e.TraceID,
+		spanID:       e.SpanID,
+		parentSpanID: e.ParentSpanID,
+		spanKind:     e.SpanKind,
+	}
+	var (
+		resource string
+		ra       map[string]any
+	)
+	if len(e.ResourceAttributes) > 0 {
+		b := enc.appendAny(nil, e.ResourceAttributes) // maps are written in key order
+		resource = string(b)
+		ra, _ = decodeJSON(b).(map[string]any)
+	}
+
+	attrs := make(map[string]any)
+	if props != nil {
+		written := 0
+		for i := props.nodes[rootNode].first; i != noNode; i = props.nodes[i].next {
+			n := &props.nodes[i]
+			if max := h.limits.MaxProperties; max > 0 && written >= max {
+				enc.report.properties++
+				continue
+			}
+			if strings.HasPrefix(n.key, "@") {
+				switch h.reservedKeyPolicy {
+				case ReservedKeyDrop:
+					continue
+				case ReservedKeyOverwrite:
+					// the property replaces the CLEF field
+					var s string
+					if !n.group && n.value.Kind() == slog.KindString {
+						s = n.value.String()
+					}
+					f.set(n.key, s)
+					written++
+					continue
+				}
+			}
+			enc.push(n.key)
+			props.otlpNode(&enc, attrs, n.key, i)
+			enc.pop()
+			written++
+		}
+	}
+	if !enc.report.empty() {
+		setFlattened(attrs, limitsKey, decodeJSON(enc.report.append(nil)))
+	}
+	return newOTLPRecord(resource, ra, &f, !e.SpanStart.IsZero(), attrs)
+}
+
+// otlpNode adds the value of node i to attrs by key, and the values of a
+// group by their dotted keys.
+func (t *propTree) otlpNode(enc *valueEncoder, attrs map[string]any, key string, i int32) {
+	n := &t.nodes[i]
+	if !n.group {
+		setFlattened(attrs, key, enc.otlpPropValue(n.value))
+		return
+	}
+	if !enc.nest() {
+		attrs[key] = depthMarker
+		return
+	}
+	defer enc.unnest()
+
+	if n.first == noNode {
+		attrs[key] = map[string]any{}
+		return
+	}
+	written := 0
+	for c := n.first; c != noNode; c = t.nodes[c].next {
+		if max := enc.limits.MaxElements; max > 0 && written == max {
+			enc.note(&enc.report.elements)
+			break
+		}
+		enc.push(t.nodes[c].key)
+		t.otlpNode(enc, attrs, key+"."+t.nodes[c].key, c)
+		enc.pop()
+		written++
+	}
+}
+
+// otlpPropValue converts a property value for otlpAttributes. Scalars keep their
+// type, so a float stays a double even if it is whole, NaN or infinite, which
+// JSON can't tell. Anything else is written as it is for CLEF, through the
+// converters, and decoded.
+func (enc *valueEncoder) otlpPropValue(v slog.Value) any {
+	if v.Kind() == slog.KindLogValuer {
+		v = v.Resolve()
+	}
+	if len(enc.converters) == 0 {
+		switch v.Kind() {
+		case slog.KindString:
+			return enc.limitString(v.String())
+		case slog.KindInt64:
+			return v.Int64()
+		case slog.KindUint64:
+			if u := v.Uint64(); u <= math.MaxInt64 {
+				return int64(u)
+			}
+			return float64(v.Uint64())
+		case slog.KindFloat64:
+			return v.Float64()
+		case slog.KindBool:
+			return v.Bool()
+		case slog.KindDuration:
+			return v.Duration().String()
+		case slog.KindTime:
+			return v.Time().Format(time.RFC3339Nano)
+		}
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	*buf = enc.appendValue(*buf, v)
+	return decodeJSON(*buf)
+}
+
+// decodeJSON decodes a value written by a valueEncoder, keeping integers
+// apart from floats.
+func decodeJSON(b []byte) any {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return string(b)
+	}
+	return v
+}
+
+// decodeOTLPRecord converts a CLEF line written by appendEvent, for lines
+// replayed from the spool. Nested properties, such as those of slog groups
+// and of dotted keys, are flattened into dotted keys as convertOTLP does.
+func decodeOTLPRecord(line []byte, span bool) (*otlpRecord, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber() // keep integers apart from floats
+	var m map[string]any
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	var (
+		f     otlpFields
+		ra    map[string]any
+		attrs = make(map[string]any, len(m))
+	)
+	for k, v := range m {
+		switch {
+		case strings.HasPrefix(k, "@@"):
+			// an escaped property, which doesn't clash with anything in OTLP
+			setFlattened(attrs, k[1:], v)
+		case k == "@ra":
+			ra, _ = v.(map[string]any)
+		case strings.HasPrefix(k, "@"):
+			if s, ok := v.(string); ok {
+				f.set(k, s)
+			}
+		default:
+			setFlattened(attrs, k, v)
+		}
+	}
+	resource, err := json.Marshal(ra) // maps are marshaled in key order
+	if err != nil {
+		return nil, err
+	}
+	return newOTLPRecord(string(resource), ra, &f, span, attrs), nil
+}
+
+// isSpanLine reports whether a CLEF line is a span, which is sent to the
+// traces endpoint. It is only needed for lines replayed from the spool;
+// queued entries know what they are.
+func isSpanLine(line []byte) bool {
+	var l struct {
+		SpanStart string `json:"@st"`
+	}
+	return json.Unmarshal(line, &l) == nil && l.SpanStart != ""
+}
+
+// otlpBody converts entries, all log events or all spans, to the body of an
+// OTLP export request. It returns the URL to send it to.
+func (h *SeqHandler) otlpBody(entries []entry) (string, []byte, error) {
+	// records with the same resource share an entry of the request
+	var (
+		keys   []string
+		groups = make(map[string][]*otlpRecord)
+	)
+	for _, e := range entries {
+		r := e.otlp
+		if r == nil {
+			var err error
+			if r, err = decodeOTLPRecord(e.line, e.span); err != nil {
+				return "", nil, fmt.Errorf("slogseq: decoding event for OTLP: %w", err)
+			}
+		}
+		if _, ok := groups[r.resource]; !ok {
+			keys = append(keys, r.resource)
+		}
+		groups[r.resource] = append(groups[r.resource], r)
+	}
+
+	// Both requests only have a repeated field 1, so they are written here
+	// rather than depending on the collector packages.
+	var body []byte
+	url := strings.TrimSuffix(h.seqURL, "/")
+	if entries[0].span {
+		url += otlpTracesPath
+		for _, key := range keys {
+			msg, err := proto.Marshal(otlpResourceSpans(groups[key]))
+			if err != nil {
+				return "", nil, err
+			}
+			body = protowire.AppendTag(body, 1, protowire.BytesType)
+			body = protowire.AppendBytes(body, msg)
+		}
+	} else {
+		url += otlpLogsPath
+		for _, key := range keys {
+			msg, err := proto.Marshal(otlpResourceLogs(groups[key]))
+			if err != nil {
+				return "", nil, err
+			}
+			body = protowire.AppendTag(body, 1, protowire.BytesType)
+			body = protowire.AppendBytes(body, msg)
+		}
+	}
+	return url, body, nil
+}
+
+// otlpResource splits resource attributes into the resource and the
+// instrumentation scope, the reverse of resourceAttributes.
+func otlpResource(ra map[string]any) (*resourcepb.Resource, *commonpb.InstrumentationScope) {
+	attrs := make(map[string]any)
+	flattenDotted(attrs, "", ra)
+	scope := &commonpb.InstrumentationScope{}
+	if name, ok := attrs["otel.scope.name"].(string); ok {
+		scope.Name = name
+		delete(attrs, "otel.scope.name")
+	}
+	if version, ok := attrs["otel.scope.version"].(string); ok {
+		scope.Version = version
+		delete(attrs, "otel.scope.version")
+	}
+	return &resourcepb.Resource{Attributes: otlpAttributes(attrs)}, scope
+}
+
+// flattenDotted stores the values of nested maps in dst by their dotted key.
+func flattenDotted(dst map[string]any, prefix string, m map[string]any) {
+	for k, v := range m {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		setFlattened(dst, k, v)
+	}
+}
+
+// setFlattened stores v in dst by key, or its values by their dotted keys if it
+// is a non-empty map.
+func setFlattened(dst map[string]any, key string, v any) {
+	if nested, ok := v.(map[string]any); ok && len(nested) > 0 {
+		flattenDotted(dst, key, nested)
+		return
+	}
+	dst[key] = v
+}
+
+func otlpResourceLogs(records []*otlpRecord) *logspb.ResourceLogs {
+	res, scope := otlpResource(records[0].ra)
+	logs := make([]*logspb.LogRecord, len(records))
+	for i, r := range records {
+		logs[i] = r.log
+	}
+	return &logspb.ResourceLogs{
+		Resource:  res,
+		ScopeLogs: []*logspb.ScopeLogs{{Scope: scope, LogRecords: logs}},
+	}
+}
+
+func otlpResourceSpans(records []*otlpRecord) *tracepb.ResourceSpans {
+	res, scope := otlpResource(records[0].ra)
+	spans := make([]*tracepb.Span, len(records))
+	for i, r := range records {
+		spans[i] = r.span
+	}
+	return &tracepb.ResourceSpans{
+		Resource:   res,
+		ScopeSpans: []*tracepb.ScopeSpans{{Scope: scope, Spans: spans}},
+	}
+}
+
+// newOTLPRecord converts an event from its fields and its properties,
+// converted to attrs. An exception is added as the attribute Seq knows it by.
+func newOTLPRecord(resource string, ra map[string]any, f *otlpFields, span bool, attrs map[string]any) *otlpRecord {
+	if f.exception != "" {
+		attrs["exception.stacktrace"] = f.exception
+	}
+	r := &otlpRecord{resource: resource, ra: ra}
+	if span {
+		r.span = f.span(attrs)
+	} else {
+		r.log = f.logRecord(attrs)
+	}
+	return r
+}
+
+// logRecord converts a log event. The message, or the template if there is
+// none, is the body; a template is also added as the attribute Seq knows it
+// by.
+func (f *otlpFields) logRecord(attrs map[string]any) *logspb.LogRecord {
+	t := otlpTime(f.time)
+	r := &logspb.LogRecord{
+		TimeUnixNano:         t,
+		ObservedTimeUnixNano: t,
+		SeverityText:         f.level,
+		SeverityNumber:       otlpSeverity(f.level),
+		TraceId:              otlpID(f.traceID),
+		SpanId:               otlpID(f.spanID),
+	}
+	body := f.message
+	if f.template != "" {
+		if body == "" {
+			body = f.template
+		}
+		attrs["message_template.text"] = f.template
+	}
+	r.Body = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: body}}
+	r.Attributes = otlpAttributes(attrs)
+	return r
+}
+
+// span converts a span, whose status was written as the otel.status_code and
+// otel.status_description properties.
+func (f *otlpFields) span(attrs map[string]any) *tracepb.Span {
+	s := &tracepb.Span{
+		TraceId:           otlpID(f.traceID),
+		SpanId:            otlpID(f.spanID),
+		ParentSpanId:      otlpID(f.parentSpanID),
+		Name:              f.message,
+		Kind:              otlpSpanKind(f.spanKind),
+		StartTimeUnixNano: otlpTime(f.spanStart),
+		EndTimeUnixNano:   otlpTime(f.time),
+		Status:            &tracepb.Status{},
+	}
+	if s.Name == "" {
+		s.Name = f.template
+	}
+	if code, ok := attrs["otel.status_code"].(string); ok {
+		switch code {
+		case "OK":
+			s.Status.Code = tracepb.Status_STATUS_CODE_OK
+		case "ERROR":
+			s.Status.Code = tracepb.Status_STATUS_CODE_ERROR
+		}
+		delete(attrs, "otel.status_code")
+	}
+	if desc, ok := attrs["otel.status_description"].(string); ok {
+		s.Status.Message = desc
+		delete(attrs, "otel.status_description")
+	}
+	s.Attributes = otlpAttributes(attrs)
+	return s
+}
+
+func otlpTime(t time.Time) uint64 {
+	if t.IsZero() || t.UnixNano() < 0 {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// otlpID decodes a hex trace or span ID.
+func otlpID(id string) []byte {
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+// otlpSeverity maps a CLEF level onto a severity number, offsetting the slog
+// level by SeverityInfo1 as LogExporter does the other way around.
+func otlpSeverity(level string) logspb.SeverityNumber {
+	l, ok := parseCLEFLevel(level)
+	if !ok {
+		return logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED
+	}
+	n := int(l-slog.LevelInfo) + int(logspb.SeverityNumber_SEVERITY_NUMBER_INFO)
+	return logspb.SeverityNumber(min(max(n, 1), 24))
+}
+
+func otlpSpanKind(kind string) tracepb.Span_SpanKind {
+	switch kind {
+	case "internal":
+		return tracepb.Span_SPAN_KIND_INTERNAL
+	case "server":
+		return tracepb.Span_SPAN_KIND_SERVER
+	case "client":
+		return tracepb.Span_SPAN_KIND_CLIENT
+	case "producer":
+		return tracepb.Span_SPAN_KIND_PRODUCER
+	case "consumer":
+		return tracepb.Span_SPAN_KIND_CONSUMER
+	default:
+		return tracepb.Span_SPAN_KIND_UNSPECIFIED
+	}
+}
+
+// otlpAttributes converts properties, decoded from JSON or converted by
+// otlpPropValue, to attributes in key order.
+func otlpAttributes(m map[string]any) []*commonpb.KeyValue {
+	if len(m) == 0 {
+		return nil
+	}
+	kvs := make([]*commonpb.KeyValue, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		kvs = append(kvs, &commonpb.KeyValue{Key: k, Value: otlpValue(m[k])})
+	}
+	return kvs
+}
+
+func otlpValue(v any) *commonpb.AnyValue {
+	switch v := v.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+		}
+		f, _ := v.Float64()
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: f}}
+	case []any:
+		values := make([]*commonpb.AnyValue, len(v))
+		for i, e := range v {
+			values[i] = otlpValue(e)
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]any:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: otlpAttributes(v)}}}
+	default:
+		// null
+		return &commonpb.AnyValue{}
+	}
+}
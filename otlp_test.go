@@ -0,0 +1,278 @@
+package slogseq
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// newOTLPHandler returns a handler sending OTLP to seq.
+func newOTLPHandler(seq *fakeSeq) *SeqHandler {
+	handler := newSeqHandler("http://seq:5341/ingest/otlp/")
+	handler.protocol = ProtocolOTLP
+	handler.apiKey = "key"
+	handler.workers = []worker{{}}
+	handler.client = seq.client()
+	return handler
+}
+
+// otlpMessages decodes the repeated field 1 of an export request.
+func otlpMessages[M proto.Message](t *testing.T, body []byte, newMsg func() M) []M {
+	t.Helper()
+	var msgs []M
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		require.GreaterOrEqual(t, n, 0)
+		require.Equal(t, protowire.Number(1), num)
+		require.Equal(t, protowire.BytesType, typ)
+		body = body[n:]
+		b, n := protowire.ConsumeBytes(body)
+		require.GreaterOrEqual(t, n, 0)
+		body = body[n:]
+		msg := newMsg()
+		require.NoError(t, proto.Unmarshal(b, msg))
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestOTLP_Logs(t *testing.T) {
+	seq := newFakeSeq(200)
+	handler := newOTLPHandler(seq)
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	ra := map[string]any{
+		"service": map[string]any{"name": "api"},
+		"otel":    map[string]any{"scope": map[string]any{"name": "auth"}},
+	}
+
+	entries := testEntries(t,
+		CLEFEvent{
+			Timestamp:          ts,
+			Level:              "Warning",
+			Message:            "disk almost full",
+			Exception:          "stack",
+			TraceID:            "0102030405060708090a0b0c0d0e0f10",
+			SpanID:             "0102030405060708",
+			ResourceAttributes: ra,
+			Properties: map[string]any{
+				"free":  int64(10),
+				"ratio": 0.5,
+				"disk":  map[string]any{"name": "sda"},
+				"tags":  []any{"a", true},
+				"@l":    "escaped",
+			},
+		},
+		CLEFEvent{Timestamp: ts, Level: "Information", MessageTemplate: "Hello {name}", Properties: map[string]any{"name": "world"}},
+	)
+	n, err := handler.postEntries(context.Background(), &handler.workers[0], entries)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	requests := seq.received()
+	require.Len(t, requests, 1)
+	req := requests[0]
+	assert.Equal(t, "/ingest/otlp/v1/logs", req.path)
+	assert.Equal(t, "application/x-protobuf", req.header.Get("Content-Type"))
+	assert.Equal(t, "key", req.header.Get("X-Seq-ApiKey"))
+
+	rls := otlpMessages(t, req.body, func() *logspb.ResourceLogs { return &logspb.ResourceLogs{} })
+	require.Len(t, rls, 2, "one per resource")
+
+	rl := rls[0]
+	assert.Equal(t, "service.name", rl.Resource.Attributes[0].Key)
+	assert.Equal(t, "api", rl.Resource.Attributes[0].Value.GetStringValue())
+	require.Len(t, rl.ScopeLogs, 1)
+	assert.Equal(t, "auth", rl.ScopeLogs[0].Scope.Name)
+
+	r := rl.ScopeLogs[0].LogRecords[0]
+	assert.Equal(t, uint64(ts.UnixNano()), r.TimeUnixNano)
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_WARN, r.SeverityNumber)
+	assert.Equal(t, "Warning", r.SeverityText)
+	assert.Equal(t, "disk almost full", r.Body.GetStringValue())
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, r.TraceId)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, r.SpanId)
+
+	attrs := make(map[string]*commonpb.AnyValue)
+	for _, kv := range r.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "escaped", attrs["@l"].GetStringValue())
+	assert.Equal(t, "sda", attrs["disk.name"].GetStringValue(), "nested properties get dotted keys")
+	assert.Equal(t, "stack", attrs["exception.stacktrace"].GetStringValue())
+	assert.Equal(t, int64(10), attrs["free"].GetIntValue())
+	assert.Equal(t, 0.5, attrs["ratio"].GetDoubleValue())
+	assert.True(t, attrs["tags"].GetArrayValue().Values[1].GetBoolValue())
+
+	templated := rls[1].ScopeLogs[0].LogRecords[0]
+	assert.Nil(t, rls[1].Resource.Attributes)
+	assert.Equal(t, "Hello {name}", templated.Body.GetStringValue())
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_INFO, templated.SeverityNumber)
+	assert.Equal(t, "message_template.text", templated.Attributes[0].Key)
+	assert.Equal(t, "name", templated.Attributes[1].Key)
+}
+
+func TestOTLP_SpansGoToTheTracesEndpoint(t *testing.T) {
+	seq := newFakeSeq(200)
+	handler := newOTLPHandler(seq)
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	entries := testEntries(t,
+		CLEFEvent{Timestamp: start, Level: "Information", Message: "step", TraceID: "0102030405060708090a0b0c0d0e0f10", SpanID: "0102030405060708"},
+		CLEFEvent{
+			Timestamp:    start.Add(time.Second),
+			SpanStart:    start,
+			Level:        "Error",
+			Message:      "GET /items",
+			SpanKind:     "server",
+			TraceID:      "0102030405060708090a0b0c0d0e0f10",
+			SpanID:       "0102030405060708",
+			ParentSpanID: "0807060504030201",
+			Properties:   map[string]any{"otel.status_code": "ERROR", "otel.status_description": "boom", "http.method": "GET"},
+		},
+		CLEFEvent{Timestamp: start, Level: "Information", Message: "after"},
+	)
+	n, err := handler.postEntries(context.Background(), &handler.workers[0], entries)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	requests := seq.received()
+	require.Len(t, requests, 3, "the span is sent on its own")
+	assert.Equal(t, "/ingest/otlp/v1/logs", requests[0].path)
+	assert.Equal(t, "/ingest/otlp/v1/traces", requests[1].path)
+	assert.Equal(t, "/ingest/otlp/v1/logs", requests[2].path)
+
+	rss := otlpMessages(t, requests[1].body, func() *tracepb.ResourceSpans { return &tracepb.ResourceSpans{} })
+	require.Len(t, rss, 1)
+	span := rss[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "GET /items", span.Name)
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, span.Kind)
+	assert.Equal(t, uint64(start.UnixNano()), span.StartTimeUnixNano)
+	assert.Equal(t, uint64(start.Add(time.Second).UnixNano()), span.EndTimeUnixNano)
+	assert.Equal(t, []byte{8, 7, 6, 5, 4, 3, 2, 1}, span.ParentSpanId)
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, span.Status.Code)
+	assert.Equal(t, "boom", span.Status.Message)
+	require.Len(t, span.Attributes, 1, "the status isn't an attribute")
+	assert.Equal(t, "http.method", span.Attributes[0].Key)
+}
+
+func TestOTLP_SpansKnownWithoutDecoding(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := testEntries(t,
+		CLEFEvent{Timestamp: start, Message: "log"},
+		CLEFEvent{Timestamp: start, SpanStart: start, Message: "span"},
+	)
+
+	assert.False(t, entries[0].span)
+	assert.True(t, entries[1].span)
+	assert.False(t, isSpanLine(entries[0].line), "spooled lines are told apart the same way")
+	assert.True(t, isSpanLine(entries[1].line))
+}
+
+func TestOTLP_ConvertedWhenEnqueued(t *testing.T) {
+	seq := newFakeSeq(200)
+	handler := newQueueingHandler(WithProtocol(ProtocolOTLP), WithHTTPClient(seq.client()))
+	defer handler.Close()
+
+	slog.New(handler).Info("converted",
+		"whole", 2.0,
+		"nan", math.NaN(),
+		"count", 3,
+		"big", uint64(math.MaxUint64),
+		"elapsed", time.Second,
+		"numeric", "42",
+	)
+	e := <-handler.workers[0].eventsCh
+	require.NotNil(t, e.otlp)
+	e.line = []byte("not JSON\n") // only lines replayed from the spool are decoded
+	_, err := handler.postEntries(context.Background(), &handler.workers[0], []entry{e})
+	require.NoError(t, err)
+
+	requests := seq.received()
+	require.Len(t, requests, 1)
+	rls := otlpMessages(t, requests[0].body, func() *logspb.ResourceLogs { return &logspb.ResourceLogs{} })
+	attrs := make(map[string]*commonpb.AnyValue)
+	for _, kv := range rls[0].ScopeLogs[0].LogRecords[0].Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	assert.Equal(t, 2.0, attrs["whole"].GetValue().(*commonpb.AnyValue_DoubleValue).DoubleValue, "JSON would make it an integer")
+	assert.True(t, math.IsNaN(attrs["nan"].GetDoubleValue()), "JSON would make it a string")
+	assert.Equal(t, int64(3), attrs["count"].GetIntValue())
+	assert.Equal(t, float64(math.MaxUint64), attrs["big"].GetDoubleValue())
+	assert.Equal(t, "1s", attrs["elapsed"].GetStringValue())
+	assert.Equal(t, "42", attrs["numeric"].GetStringValue())
+}
+
+func TestOTLP_ConvertedLikeSpooledLines(t *testing.T) {
+	handler := newQueueingHandler(
+		WithProtocol(ProtocolOTLP),
+		WithLimits(Limits{MaxStringLength: 8, MaxElements: 6, MaxDepth: 2, MaxProperties: 6}),
+	)
+	defer handler.Close()
+	handler.resource = map[string]any{"service": map[string]any{"name": "api"}}
+
+	logger := slog.New(handler)
+	logger.With("@l", "escaped").WithGroup("req").Info("failed",
+		"path", "/a/very/long/path",
+		"headers", map[string]any{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7},
+		"nested", map[string]any{"x": map[string]any{"y": map[string]any{"z": 1}}},
+		"tags", []any{"a", true},
+		slog.Group("user", "id", 7, "admin", false),
+		"err", errors.New("boom"),
+	)
+	logger.Info("bounded", "a", 1, "b", 2, "c", 3, "d", 4, "e", 5, "f", 6, "g", 7, "h", 8)
+
+	for range 2 {
+		e := <-handler.workers[0].eventsCh
+		require.NotNil(t, e.otlp)
+		decoded, err := decodeOTLPRecord(e.line, e.span)
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(decoded.log, e.otlp.log), "enqueued:\n%v\nspooled:\n%v", e.otlp.log, decoded.log)
+		assert.Equal(t, decoded.ra, e.otlp.ra)
+		assert.Contains(t, string(e.line), limitsKey)
+	}
+}
+
+func TestOTLP_NestedPropertiesGetDottedKeys(t *testing.T) {
+	seq := newFakeSeq(200)
+	handler := newQueueingHandler(WithProtocol(ProtocolOTLP), WithHTTPClient(seq.client()))
+	defer handler.Close()
+
+	slog.New(handler).WithGroup("req").Info("handled", "http.method", "GET", "user", "ann")
+	entries := []entry{<-handler.workers[0].eventsCh}
+	_, err := handler.postEntries(context.Background(), &handler.workers[0], entries)
+	require.NoError(t, err)
+
+	requests := seq.received()
+	require.Len(t, requests, 1)
+	rls := otlpMessages(t, requests[0].body, func() *logspb.ResourceLogs { return &logspb.ResourceLogs{} })
+	var keys []string
+	for _, kv := range rls[0].ScopeLogs[0].LogRecords[0].Attributes {
+		keys = append(keys, kv.Key)
+	}
+	assert.Equal(t, []string{"req.http.method", "req.user"}, keys)
+}
+
+func TestOTLP_Severity(t *testing.T) {
+	for level, expected := range map[string]logspb.SeverityNumber{
+		"Verbose":     logspb.SeverityNumber_SEVERITY_NUMBER_TRACE,
+		"Debug":       logspb.SeverityNumber_SEVERITY_NUMBER_DEBUG,
+		"Information": logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		"Warning":     logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+		"Error":       logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+		"Fatal":       logspb.SeverityNumber_SEVERITY_NUMBER_FATAL,
+		"Notice":      logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED,
+	} {
+		assert.Equal(t, expected, otlpSeverity(level), level)
+	}
+}
@@ -0,0 +1,118 @@
+package slogseq
+
+import "time"
+
+// defaultQueueSize is the number of events each worker can hold before its
+// overflow policy kicks in.
+const defaultQueueSize = 1000
+
+// OverflowPolicy decides what happens to an event when the queue of the
+// worker it is handed to is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the new event. This is the default.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest event in the queue to make room
+	// for the new one.
+	OverflowDropOldest
+	// OverflowBlock waits for room in the queue, for at most the timeout
+	// given with WithBlockTimeout, after which the new event is dropped.
+	OverflowBlock
+	// OverflowSpill writes the new event to the disk spool, to be sent once
+	// the worker catches up. Without a spool set up with WithSpoolDir, the
+	// event is dropped.
+	OverflowSpill
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowBlock:
+		return "block"
+	case OverflowSpill:
+		return "spill"
+	default:
+		return "unknown"
+	}
+}
+
+// enqueue hands an event to one of a worker's queues, applying the overflow
+// policy if it is full or the memory budget is used up. Dropped events are
+// counted as DroppedQueueFull.
+func (h *SeqHandler) enqueue(w *worker, ch chan entry, e entry) {
+	size := h.memory.size(e)
+	if !h.reserveMemory(w, ch, e, size) {
+		return
+	}
+	if !h.send(w, ch, e) {
+		h.memory.release(size)
+	}
+}
+
+// send puts an event into a queue, applying the overflow policy if it is
+// full. It returns false if the event was dropped or spilled to disk instead.
+func (h *SeqHandler) send(w *worker, ch chan entry, e entry) bool {
+	select {
+	case ch <- e:
+		w.stats.enqueued.Add(1)
+		return true
+	default:
+	}
+
+	switch h.overflowPolicy {
+	case OverflowDropOldest:
+		for {
+			select {
+			case old := <-ch:
+				h.memory.release(h.memory.size(old))
+				h.recordDropped(w, dropQueueFull, 1)
+			default:
+			}
+			select {
+			case ch <- e:
+				w.stats.enqueued.Add(1)
+				return true
+			default:
+				// the room was taken by another goroutine, try again
+			}
+		}
+	case OverflowBlock:
+		if h.blockTimeout <= 0 {
+			ch <- e
+			w.stats.enqueued.Add(1)
+			return true
+		}
+		timer := time.NewTimer(h.blockTimeout)
+		defer timer.Stop()
+		select {
+		case ch <- e:
+			w.stats.enqueued.Add(1)
+			return true
+		case <-timer.C:
+			h.recordDropped(w, dropQueueFull, 1)
+			return false
+		}
+	case OverflowSpill:
+		if !h.spoolEvents(w, []entry{e}) {
+			h.recordDropped(w, dropQueueFull, 1)
+		}
+		return false
+	default:
+		h.recordDropped(w, dropQueueFull, 1)
+		return false
+	}
+}
+
+// isPriority reports whether an event of the given CLEF level goes into a
+// worker's priority queue.
+func (h *SeqHandler) isPriority(level string) bool {
+	if h.priorityLevel == nil {
+		return false
+	}
+	l, ok := parseCLEFLevel(level)
+	return ok && l >= h.priorityLevel.Level()
+}
@@ -0,0 +1,141 @@
+package slogseq
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverflow_DropNewest(t *testing.T) {
+	handler := &SeqHandler{workers: []worker{{eventsCh: make(chan entry, 2)}}}
+
+	for _, m := range []string{"a", "b", "c"} {
+		handler.HandleCLEFEvent(CLEFEvent{Message: m})
+	}
+
+	assert.Equal(t, "a", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	assert.Equal(t, "b", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	assert.Equal(t, uint64(1), handler.Stats().Workers[0].DroppedQueueFull)
+}
+
+func TestOverflow_DropOldest(t *testing.T) {
+	handler := &SeqHandler{
+		overflowPolicy: OverflowDropOldest,
+		workers:        []worker{{eventsCh: make(chan entry, 2)}},
+	}
+
+	for _, m := range []string{"a", "b", "c"} {
+		handler.HandleCLEFEvent(CLEFEvent{Message: m})
+	}
+
+	assert.Equal(t, "b", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	assert.Equal(t, "c", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	ws := handler.Stats().Workers[0]
+	assert.Equal(t, uint64(1), ws.DroppedQueueFull)
+	assert.Equal(t, uint64(3), ws.Enqueued)
+}
+
+func TestOverflow_BlockWithTimeout(t *testing.T) {
+	handler := &SeqHandler{
+		overflowPolicy: OverflowBlock,
+		blockTimeout:   20 * time.Millisecond,
+		workers:        []worker{{eventsCh: make(chan entry, 1)}},
+	}
+	handler.HandleCLEFEvent(CLEFEvent{Message: "a"})
+
+	start := time.Now()
+	handler.HandleCLEFEvent(CLEFEvent{Message: "timed out"})
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.Equal(t, uint64(1), handler.Stats().Workers[0].DroppedQueueFull)
+
+	// once there is room again, a blocked event gets in
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-handler.workers[0].eventsCh
+	}()
+	handler.HandleCLEFEvent(CLEFEvent{Message: "waited"})
+	assert.Equal(t, "waited", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	assert.Equal(t, uint64(1), handler.Stats().Workers[0].DroppedQueueFull)
+}
+
+func TestOverflow_Spill(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+	handler := &SeqHandler{
+		overflowPolicy: OverflowSpill,
+		spool:          s,
+		workers:        []worker{{eventsCh: make(chan entry, 1)}},
+	}
+
+	handler.HandleCLEFEvent(CLEFEvent{Message: "queued"})
+	handler.HandleCLEFEvent(CLEFEvent{Message: "spilled"})
+
+	assert.Equal(t, 1, s.len())
+	ws := handler.Stats().Workers[0]
+	assert.Equal(t, uint64(1), ws.Spooled)
+	assert.Zero(t, ws.DroppedQueueFull)
+
+	// without a spool the event is dropped
+	handler.spool = nil
+	handler.HandleCLEFEvent(CLEFEvent{Message: "dropped"})
+	assert.Equal(t, uint64(1), handler.Stats().Workers[0].DroppedQueueFull)
+}
+
+func TestWithQueueSize(t *testing.T) {
+	_, handler := NewLogger("http://example.com", WithQueueSize(5), WithOverflowPolicy(OverflowDropOldest))
+	defer handler.Close()
+
+	assert.Equal(t, 5, cap(handler.workers[0].eventsCh))
+	assert.Equal(t, OverflowDropOldest, handler.overflowPolicy)
+}
+
+func TestPriority_WarningsSkipTheQueue(t *testing.T) {
+	handler := &SeqHandler{
+		priorityLevel: slog.LevelWarn,
+		workers: []worker{{
+			eventsCh:   make(chan entry, 1),
+			priorityCh: make(chan entry, 1),
+		}},
+	}
+
+	handler.HandleCLEFEvent(CLEFEvent{Message: "debug", Level: "Debug"})
+	handler.HandleCLEFEvent(CLEFEvent{Message: "flood", Level: "Debug"})
+	handler.HandleCLEFEvent(CLEFEvent{Message: "error", Level: "Error"})
+
+	assert.Equal(t, "error", decodeEntry(t, <-handler.workers[0].priorityCh).Message)
+	assert.Equal(t, "debug", decodeEntry(t, <-handler.workers[0].eventsCh).Message)
+	ws := handler.Stats().Workers[0]
+	assert.Equal(t, uint64(2), ws.Enqueued)
+	assert.Equal(t, uint64(1), ws.DroppedQueueFull)
+}
+
+func TestPriority_SentWithoutWaitingForFlushInterval(t *testing.T) {
+	seq := newFakeSeq(201)
+	logger, handler := NewLogger("http://example.com",
+		WithHTTPClient(seq.client()),
+		WithFlushInterval(time.Hour),
+	)
+	defer handler.Close()
+
+	logger.Info("waits for the next flush")
+	logger.Warn("sent right away")
+
+	assert.Eventually(t, func() bool {
+		return len(seq.bodies()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, seq.bodies()[0], "sent right away")
+}
+
+func TestWithPriorityLevel(t *testing.T) {
+	_, handler := NewLogger("http://example.com", WithPriorityLevel(nil))
+	defer handler.Close()
+	assert.Nil(t, handler.workers[0].priorityCh)
+
+	_, handler = NewLogger("http://example.com", WithPriorityLevel(slog.LevelError))
+	defer handler.Close()
+	assert.False(t, handler.isPriority("Warning"))
+	assert.True(t, handler.isPriority("Fatal"))
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package slogseq
+
+// raceEnabled reports whether the tests run with the race detector, which
+// adds allocations of its own.
+const raceEnabled = true
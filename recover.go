@@ -0,0 +1,137 @@
+package slogseq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// defaultRecoverFlushTimeout bounds how long the recovery helpers wait for the
+// Fatal event to reach Seq.
+const defaultRecoverFlushTimeout = 5 * time.Second
+
+// PanicError is a recovered panic. It is logged under the "error" key, so a
+// SeqHandler renders the panic value and the stack of the panicking goroutine
+// into the event's exception (@x).
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value any
+	stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Stack returns the stack of the panicking goroutine, as formatted by
+// runtime/debug.Stack.
+func (e *PanicError) Stack() []byte {
+	return e.stack
+}
+
+// RecoverOption is an option for RecoverAndLog, GoSafe and RecoverHandler.
+type RecoverOption func(*recoverConfig)
+
+type recoverConfig struct {
+	repanic      bool
+	flushTimeout time.Duration
+}
+
+// Repanic makes the recovery helpers panic again with the original value once
+// the panic has been logged.
+func Repanic() RecoverOption {
+	return func(c *recoverConfig) {
+		c.repanic = true
+	}
+}
+
+// FlushTimeout sets how long the recovery helpers wait for the Fatal event to
+// be sent to Seq. Default is 5 seconds.
+func FlushTimeout(d time.Duration) RecoverOption {
+	return func(c *recoverConfig) {
+		c.flushTimeout = d
+	}
+}
+
+func newRecoverConfig(opts []RecoverOption) recoverConfig {
+	c := recoverConfig{flushTimeout: defaultRecoverFlushTimeout}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// RecoverAndLog recovers a panic, logs it as a Fatal event with the panic
+// value and the goroutine's stack, and waits for the event to be sent if the
+// logger's handler is a SeqHandler. It must be deferred directly:
+//
+//	defer slogseq.RecoverAndLog(ctx, logger)
+func RecoverAndLog(ctx context.Context, logger *slog.Logger, opts ...RecoverOption) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	cfg := newRecoverConfig(opts)
+	logPanic(ctx, logger, cfg, v)
+	if cfg.repanic {
+		panic(v)
+	}
+}
+
+// GoSafe runs fn in a new goroutine, recovering and logging any panic as
+// RecoverAndLog does.
+func GoSafe(ctx context.Context, logger *slog.Logger, fn func(), opts ...RecoverOption) {
+	go func() {
+		defer RecoverAndLog(ctx, logger, opts...)
+		fn()
+	}()
+}
+
+// RecoverHandler wraps an http.Handler, recovering and logging panics as
+// RecoverAndLog does, along with the request's method and path. Unless
+// Repanic is given, the client gets a 500 Internal Server Error.
+// http.ErrAbortHandler is passed on untouched, as net/http expects.
+func RecoverHandler(logger *slog.Logger, next http.Handler, opts ...RecoverOption) http.Handler {
+	cfg := newRecoverConfig(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+			logPanic(r.Context(), logger.With("method", r.Method, "path", r.URL.Path), cfg, v)
+			if cfg.repanic {
+				panic(v)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func logPanic(ctx context.Context, logger *slog.Logger, cfg recoverConfig, v any) {
+	pe := &PanicError{Value: v, stack: debug.Stack()}
+	logger.LogAttrs(ctx, LevelFatal, pe.Error(), slog.Any("error", pe))
+
+	// the process may be about to die, so make sure the event gets out
+	f, ok := logger.Handler().(interface{ Flush(context.Context) error })
+	if !ok {
+		return
+	}
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.flushTimeout)
+	defer cancel()
+	_ = f.Flush(flushCtx)
+}
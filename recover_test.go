@@ -0,0 +1,127 @@
+package slogseq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRecordingHandler returns a handler whose events are only sent when
+// flushed, and a function returning the events sent so far.
+func newRecordingHandler(t *testing.T) (*SeqHandler, func() []map[string]any) {
+	seq := newFakeSeq(201)
+	_, handler := NewLogger("http://example.com",
+		WithHTTPClient(seq.client()),
+		WithFlushInterval(time.Hour),
+	)
+	t.Cleanup(func() { _ = handler.Close() })
+
+	sent := func() []map[string]any {
+		var events []map[string]any
+		for _, body := range seq.bodies() {
+			for line := range strings.SplitSeq(strings.TrimSpace(body), "\n") {
+				var e map[string]any
+				require.NoError(t, json.Unmarshal([]byte(line), &e))
+				events = append(events, e)
+			}
+		}
+		return events
+	}
+	return handler, sent
+}
+
+func TestRecoverAndLog(t *testing.T) {
+	handler, sent := newRecordingHandler(t)
+
+	func() {
+		defer RecoverAndLog(context.Background(), slog.New(handler))
+		panic("boom")
+	}()
+
+	// the event was flushed before RecoverAndLog returned
+	events := sent()
+	require.Len(t, events, 1)
+	assert.Equal(t, "Fatal", events[0]["@l"])
+	assert.Equal(t, "panic: boom", events[0]["@m"])
+	assert.Equal(t, "panic: boom", events[0]["error"])
+	x := events[0]["@x"].(string)
+	assert.True(t, strings.HasPrefix(x, "*slogseq.PanicError: panic: boom\ngoroutine "), x)
+	assert.Contains(t, x, "TestRecoverAndLog")
+}
+
+func TestRecoverAndLog_ErrorValueAndRepanic(t *testing.T) {
+	handler, sent := newRecordingHandler(t)
+	cause := errors.New("broken")
+
+	assert.PanicsWithValue(t, cause, func() {
+		defer RecoverAndLog(context.Background(), slog.New(handler), Repanic())
+		panic(cause)
+	})
+
+	events := sent()
+	require.Len(t, events, 1)
+	assert.True(t, strings.HasSuffix(events[0]["@x"].(string), "\n*errors.errorString: broken"))
+}
+
+func TestRecoverAndLog_NoPanic(t *testing.T) {
+	handler, sent := newRecordingHandler(t)
+
+	func() {
+		defer RecoverAndLog(context.Background(), slog.New(handler))
+	}()
+	require.NoError(t, handler.Flush(context.Background()))
+	assert.Empty(t, sent())
+}
+
+func TestGoSafe(t *testing.T) {
+	handler, sent := newRecordingHandler(t)
+
+	done := make(chan struct{})
+	GoSafe(context.Background(), slog.New(handler), func() {
+		defer close(done)
+		panic("in goroutine")
+	})
+	<-done
+
+	assert.Eventually(t, func() bool { return len(sent()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "panic: in goroutine", sent()[0]["@m"])
+}
+
+func TestRecoverHandler(t *testing.T) {
+	handler, sent := newRecordingHandler(t)
+	h := RecoverHandler(slog.New(handler), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("handler failed")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	events := sent()
+	require.Len(t, events, 1)
+	assert.Equal(t, "Fatal", events[0]["@l"])
+	assert.Equal(t, "GET", events[0]["method"])
+	assert.Equal(t, "/orders/1", events[0]["path"])
+}
+
+func TestRecoverHandler_ErrAbortHandler(t *testing.T) {
+	handler, sent := newRecordingHandler(t)
+	h := RecoverHandler(slog.New(handler), http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	require.NoError(t, handler.Flush(context.Background()))
+	assert.Empty(t, sent())
+}
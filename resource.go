@@ -0,0 +1,54 @@
+package slogseq
+
+import (
+	"strings"
+
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// resourceAttributes converts the attributes of an OpenTelemetry resource and
+// instrumentation scope to the nested objects of a CLEF event's @ra, so that
+// "service.name" becomes {"service":{"name":...}}. It returns nil if there
+// are none.
+func resourceAttributes(res *resource.Resource, scope instrumentation.Scope) map[string]any {
+	ra := make(map[string]any)
+	if res != nil {
+		for iter := res.Iter(); iter.Next(); {
+			kv := iter.Attribute()
+			setDotted(ra, string(kv.Key), kv.Value.AsInterface())
+		}
+	}
+	if scope.Name != "" {
+		setDotted(ra, "otel.scope.name", scope.Name)
+	}
+	if scope.Version != "" {
+		setDotted(ra, "otel.scope.version", scope.Version)
+	}
+	if len(ra) == 0 {
+		return nil
+	}
+	return ra
+}
+
+// setDotted stores v in nested maps by the parts of a dotted key. Where a
+// part is already taken by a value, the rest of the key is kept as it is.
+func setDotted(m map[string]any, key string, v any) {
+	for {
+		head, rest, found := strings.Cut(key, ".")
+		if !found {
+			m[key] = v
+			return
+		}
+		child, ok := m[head].(map[string]any)
+		if !ok {
+			if _, taken := m[head]; taken {
+				m[key] = v
+				return
+			}
+			child = make(map[string]any)
+			m[head] = child
+		}
+		m, key = child, rest
+	}
+}
@@ -0,0 +1,45 @@
+package slogseq
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+func TestResourceAttributes(t *testing.T) {
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "api"),
+		attribute.String("service.version", "1.2.3"),
+		attribute.String("host", "web-1"),
+		attribute.String("host.arch", "amd64"),
+	)
+	ra := resourceAttributes(res, instrumentation.Scope{Name: "github.com/acme/api", Version: "0.1.0"})
+
+	assert.Equal(t, map[string]any{
+		"service":   map[string]any{"name": "api", "version": "1.2.3"},
+		"host":      "web-1",
+		"host.arch": "amd64", // host is already taken
+		"otel":      map[string]any{"scope": map[string]any{"name": "github.com/acme/api", "version": "0.1.0"}},
+	}, ra)
+
+	assert.Nil(t, resourceAttributes(resource.Empty(), instrumentation.Scope{}))
+	assert.Nil(t, resourceAttributes(nil, instrumentation.Scope{}))
+}
+
+func TestHandle_WithResource(t *testing.T) {
+	handler := newQueueingHandler(WithResource(resource.NewSchemaless(attribute.String("service.name", "api"))))
+	defer handler.Close()
+
+	slog.New(handler).Info("hello")
+	handler.HandleCLEFEvent(CLEFEvent{Message: "clef"})
+	handler.HandleCLEFEvent(CLEFEvent{Message: "own", ResourceAttributes: map[string]any{"service": "other"}})
+
+	expected := map[string]any{"service": map[string]any{"name": "api"}}
+	assert.Equal(t, expected, decodeEntry(t, <-handler.workers[0].eventsCh).ResourceAttributes)
+	assert.Equal(t, expected, decodeEntry(t, <-handler.workers[0].eventsCh).ResourceAttributes)
+	assert.Equal(t, map[string]any{"service": "other"}, decodeEntry(t, <-handler.workers[0].eventsCh).ResourceAttributes)
+}
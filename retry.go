@@ -0,0 +1,122 @@
+package slogseq
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy decides if and when a batch that could not be delivered to Seq
+// is sent again.
+type RetryPolicy struct {
+	// MaxAttempts is the number of consecutive failed attempts after which the
+	// pending events are dropped. 0 means keep retrying until they are purged.
+	MaxAttempts int
+	// BaseDelay is the delay after the first failure. It doubles with every
+	// consecutive failure.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts. A Retry-After header sent by
+	// Seq takes precedence over it.
+	MaxDelay time.Duration
+	// Jitter is the fraction of the delay, between 0 and 1, that is randomized
+	// so that many clients don't retry in lockstep.
+	Jitter float64
+	// Retryable reports whether a failure is transient. statusCode is 0 if no
+	// response was received. If nil, DefaultRetryable is used.
+	Retryable func(statusCode int, err error) bool
+}
+
+// DefaultRetryPolicy returns the retry policy used unless WithRetryPolicy is set.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+		Jitter:    0.2,
+		Retryable: DefaultRetryable,
+	}
+}
+
+// DefaultRetryable treats network errors, 408, 429 and 5xx responses as
+// transient. Any other status, such as 400 for a malformed payload, is permanent.
+func DefaultRetryable(statusCode int, err error) bool {
+	switch {
+	case statusCode == 0:
+		return true
+	case statusCode == http.StatusRequestTimeout, statusCode == http.StatusTooManyRequests:
+		return true
+	default:
+		return statusCode >= 500
+	}
+}
+
+// delay returns how long to wait after the given number of consecutive failures.
+func (p RetryPolicy) delay(failures int, retryAfter time.Duration) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < failures && d > 0; i++ {
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 && d > 0 {
+		d -= time.Duration(rand.Float64() * min(p.Jitter, 1) * float64(d))
+	}
+	return max(d, retryAfter)
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	var se *sendError
+	if errors.As(err, &se) && se.permanent {
+		return false
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+	statusCode := 0
+	if se != nil {
+		statusCode = se.statusCode
+	}
+	return retryable(statusCode, err)
+}
+
+var errEventTooLarge = errors.New("slogseq: event exceeds the maximum event size")
+
+// sendError describes why a batch could not be delivered.
+type sendError struct {
+	statusCode int           // 0 if no response was received
+	retryAfter time.Duration // from the Retry-After header, if any
+	permanent  bool          // the batch can never be delivered, e.g. it can't be encoded
+	err        error
+}
+
+func (e *sendError) Error() string {
+	if e.statusCode != 0 {
+		return fmt.Sprintf("slogseq: seq responded with status %d", e.statusCode)
+	}
+	return fmt.Sprintf("slogseq: %v", e.err)
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package slogseq
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	assert.Equal(t, time.Second, p.delay(1, 0))
+	assert.Equal(t, 2*time.Second, p.delay(2, 0))
+	assert.Equal(t, 8*time.Second, p.delay(4, 0))
+	assert.Equal(t, 10*time.Second, p.delay(5, 0))
+	assert.Equal(t, 10*time.Second, p.delay(100, 0))
+	assert.Equal(t, time.Minute, p.delay(1, time.Minute), "Retry-After wins over the policy")
+
+	p.Jitter = 0.5
+	for range 100 {
+		d := p.delay(2, 0)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 2*time.Second)
+	}
+}
+
+func TestDefaultRetryable(t *testing.T) {
+	assert.True(t, DefaultRetryable(0, io.EOF))
+	assert.True(t, DefaultRetryable(http.StatusTooManyRequests, nil))
+	assert.True(t, DefaultRetryable(http.StatusServiceUnavailable, nil))
+	assert.False(t, DefaultRetryable(http.StatusBadRequest, nil))
+	assert.False(t, DefaultRetryable(http.StatusUnauthorized, nil))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	d := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, time.Hour.Seconds(), d.Seconds(), 5)
+}
+
+func TestSendWithRetry_PermanentFailureDropsBatch(t *testing.T) {
+	seq := newFakeSeq(400)
+	handler := &SeqHandler{
+		client:      seq.client(),
+		seqURL:      "http://example.com",
+		retryPolicy: DefaultRetryPolicy(),
+	}
+	w := &worker{}
+
+	leftover := handler.sendWithRetry(context.Background(), w, testEntries(t, CLEFEvent{Message: "bad", Timestamp: time.Now()}))
+	assert.Nil(t, leftover, "a 400 should not be retried")
+	assert.True(t, w.nextAttempt.IsZero(), "a permanent failure should not back off")
+	assert.Equal(t, 1, seq.calls())
+}
+
+func TestFlushCurrentBatch_BacksOffAfterFailure(t *testing.T) {
+	seq := newFakeSeq(503)
+	seq.header = http.Header{"Retry-After": []string{"120"}}
+	handler := &SeqHandler{
+		client:      seq.client(),
+		seqURL:      "http://example.com",
+		retryPolicy: RetryPolicy{BaseDelay: time.Millisecond},
+	}
+	w := &worker{}
+
+	events := testEntries(t, CLEFEvent{Message: "first", Timestamp: time.Now()})
+	handler.flushCurrentBatch(context.Background(), w, &events)
+	assert.Equal(t, 1, seq.calls())
+	require.Len(t, w.retryBuffer, 1)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), w.nextAttempt, 5*time.Second)
+
+	// while backing off, batches are kept without hitting Seq
+	events = append(events, testEntries(t, CLEFEvent{Message: "second", Timestamp: time.Now()})...)
+	handler.flushCurrentBatch(context.Background(), w, &events)
+	assert.Equal(t, 1, seq.calls())
+	assert.Len(t, w.retryBuffer, 2)
+	assert.Empty(t, events)
+}
+
+func TestSendWithRetry_MaxAttempts(t *testing.T) {
+	seq := newFakeSeq(500)
+	handler := &SeqHandler{
+		client:      seq.client(),
+		seqURL:      "http://example.com",
+		retryPolicy: RetryPolicy{MaxAttempts: 3},
+	}
+	w := &worker{}
+	events := testEntries(t, CLEFEvent{Message: "event", Timestamp: time.Now()})
+
+	assert.NotNil(t, handler.sendWithRetry(context.Background(), w, events))
+	assert.NotNil(t, handler.sendWithRetry(context.Background(), w, events))
+	assert.Nil(t, handler.sendWithRetry(context.Background(), w, events), "events should be dropped after MaxAttempts")
+	assert.Equal(t, 3, seq.calls())
+	assert.Equal(t, 0, w.failures)
+}
@@ -4,6 +4,10 @@ import (
 	"log/slog"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
 )
 
 // SeqOption is an option to configure a Seq handler.
@@ -44,6 +48,60 @@ func WithBatchSize(batchSize int) SeqOption {
 	})
 }
 
+// WithMaxEventBytes sets the maximum size of a single encoded event. Events larger
+// than this are dropped, or truncated if WithTruncateOversizedEvents is set.
+// Default is 256 KiB, matching Seq's default. 0 disables the limit.
+func WithMaxEventBytes(maxBytes int) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.maxEventBytes = maxBytes
+		return h
+	})
+}
+
+// WithMaxBatchBytes sets the maximum size of a single request to Seq. Batches
+// larger than this are sent in several requests. Default is 10 MiB, matching
+// Seq's default. 0 disables the limit.
+func WithMaxBatchBytes(maxBytes int) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.maxBatchBytes = maxBytes
+		return h
+	})
+}
+
+// WithTruncateOversizedEvents makes events exceeding WithMaxEventBytes be sent
+// without their properties and with a shortened message and exception, instead
+// of being dropped. Truncated events carry a "_truncated" property with their
+// original size.
+func WithTruncateOversizedEvents(truncate bool) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.truncateOversized = truncate
+		return h
+	})
+}
+
+// WithProtocol sets the format events are sent to Seq in. With ProtocolOTLP,
+// the URL given to NewLogger is the OTLP endpoint of Seq, such as
+// http://seq:5341/ingest/otlp. Default is ProtocolCLEF.
+func WithProtocol(protocol Protocol) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.protocol = protocol
+		return h
+	})
+}
+
+// WithCompression sets the compression used for requests to Seq. level is the
+// compression level, as in compress/gzip, and is ignored for CompressionNone.
+// Default is CompressionNone.
+func WithCompression(compression Compression, level int) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.compression = compression
+		if compression == CompressionGzip {
+			h.gzipPool = newGzipPool(level)
+		}
+		return h
+	})
+}
+
 // WithFlushInterval sets the interval at which to flush the batch.
 func WithFlushInterval(flushInterval time.Duration) SeqOption {
 	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
@@ -92,6 +150,78 @@ func WithSourceKey(key string) SeqOption {
 	})
 }
 
+// WithMessageTemplates makes the handler send log messages as message
+// templates, e.g. "User {UserId} logged in", whose holes Seq fills in from the
+// event's attributes. Events with the same template share an event type (@i),
+// so they can be grouped in Seq. Format specifiers are fmt verbs, as in
+// "Took {Elapsed:.2f} ms".
+func WithMessageTemplates() SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.messageTemplates = true
+		return h
+	})
+}
+
+// WithReservedKeyPolicy sets what happens to attributes whose names start with
+// "@", which would otherwise collide with the CLEF fields. Default is
+// ReservedKeyEscape.
+func WithReservedKeyPolicy(policy ReservedKeyPolicy) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.reservedKeyPolicy = policy
+		return h
+	})
+}
+
+// WithLevelMapper sets how slog levels are mapped onto CLEF levels. Default is
+// DefaultLevelMapper.
+func WithLevelMapper(mapper func(slog.Level) CLEFLevel) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.levelMapper = mapper
+		return h
+	})
+}
+
+// WithErrorKeys sets the attribute keys whose error values are rendered into
+// the event's exception (@x), with everything they wrap and any stack traces
+// they carry. The attribute itself keeps the error message. Default is
+// "error" and "err"; no keys disables this.
+func WithErrorKeys(keys ...string) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.errorKeys = keys
+		return h
+	})
+}
+
+// WithValueConverter adds a converter for property values, to write types
+// differently than the default conversion does. Converters are tried in the
+// order they were added, and the first one that accepts a value wins.
+func WithValueConverter(fn ValueConverter) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.valueConverters = append(h.valueConverters, fn)
+		return h
+	})
+}
+
+// WithLimits caps the length of strings, the number of elements of
+// collections, the nesting depth and the number of properties of events.
+// What is cut is listed in the event's _limits property.
+func WithLimits(limits Limits) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.limits = limits
+		return h
+	})
+}
+
+// WithResource sets the OpenTelemetry resource describing the application,
+// such as the one of its TracerProvider. Its attributes are sent as the
+// resource attributes (@ra) of every event, as they are for spans.
+func WithResource(res *resource.Resource) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.resource = resourceAttributes(res, instrumentation.Scope{})
+		return h
+	})
+}
+
 // WithWorkers sets the number of workers to use for sending events.
 // Default is 1. Consider increasing this if you have a very high volume of events.
 func WithWorkers(count int) SeqOption {
@@ -102,10 +232,107 @@ func WithWorkers(count int) SeqOption {
 }
 
 // WithNonBlocking sets the handler to be non-blocking. Default is true.
-// If set to false, the handler will block until the event is sent.
+// If set to false, the handler will block until the event is queued.
+// It is a shorthand for WithOverflowPolicy with OverflowDropNewest or
+// OverflowBlock.
 func WithNonBlocking(nonBlocking bool) SeqOption {
 	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
-		h.nonBlocking = nonBlocking
+		if nonBlocking {
+			h.overflowPolicy = OverflowDropNewest
+		} else {
+			h.overflowPolicy = OverflowBlock
+		}
+		return h
+	})
+}
+
+// WithQueueSize sets the number of events each worker can queue before the
+// overflow policy applies. Default is 1000.
+func WithQueueSize(size int) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.queueSize = size
+		return h
+	})
+}
+
+// WithOverflowPolicy sets what happens to events when a worker's queue is
+// full. Default is OverflowDropNewest.
+func WithOverflowPolicy(policy OverflowPolicy) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.overflowPolicy = policy
+		return h
+	})
+}
+
+// WithMemoryLimit limits how many bytes of events the handler keeps in memory,
+// across the queues, batches and retry buffers of all workers. Event sizes are
+// estimated when they are handled. Once the limit is reached, the overflow
+// policy applies as if the queue was full. Default is 0, no limit.
+func WithMemoryLimit(bytes int64) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.memoryLimit = bytes
+		return h
+	})
+}
+
+// WithPriorityLevel sets the level from which events skip the queue: they
+// go into a separate priority queue per worker, so a flood of less important
+// events can't crowd them out, and are sent right away instead of waiting for
+// the flush interval. Default is slog.LevelWarn; nil disables the priority queue.
+func WithPriorityLevel(level slog.Leveler) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.priorityLevel = level
+		return h
+	})
+}
+
+// WithBlockTimeout sets how long OverflowBlock waits for room in the queue
+// before dropping an event. Default is 0, which waits forever.
+func WithBlockTimeout(timeout time.Duration) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.blockTimeout = timeout
+		return h
+	})
+}
+
+// WithSpoolDir enables a durable spool in dir for batches that could not be
+// delivered to Seq. Failed batches are written to segment files and replayed in
+// order once Seq accepts events again, including after a restart.
+// maxBytes limits the total size of the spool; when it is exceeded the oldest
+// segments are discarded. A maxBytes of 0 means no limit.
+func WithSpoolDir(dir string, maxBytes int64) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.spoolDir = dir
+		h.spoolMaxBytes = maxBytes
+		return h
+	})
+}
+
+// WithRetryPolicy sets how failed batches are retried. See DefaultRetryPolicy for the defaults.
+func WithRetryPolicy(policy RetryPolicy) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.retryPolicy = policy
+		return h
+	})
+}
+
+// WithErrorHandler sets a function that is called whenever a request to Seq
+// fails, and when the spool can't be opened or read. It is called from the
+// worker sending the request, so it should return quickly, and it should not
+// log through this handler to avoid a feedback loop.
+func WithErrorHandler(fn func(error)) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.errorHandler = fn
+		return h
+	})
+}
+
+// WithMeterProvider makes the handler report on its own health through
+// OpenTelemetry metrics: queue depth and retry buffer size per worker, batch
+// sizes, request durations, bytes sent and dropped events by reason.
+func WithMeterProvider(mp metric.MeterProvider) SeqOption {
+	return seqOptionFunc(func(h *SeqHandler) *SeqHandler {
+		h.meterProvider = mp
 		return h
 	})
 }
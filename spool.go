@@ -0,0 +1,289 @@
+package slogseq
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolSegmentExt = ".clef"
+	spoolTempExt    = ".tmp"
+)
+
+var errSpoolFull = errors.New("slogseq: batch does not fit in spool")
+
+// spool persists batches that could not be delivered to Seq as segment files
+// in a directory, one newline-delimited CLEF batch per segment. Segments are
+// named by a monotonically increasing sequence number so they can be replayed
+// in the order they were written, also after a restart.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []spoolSegment // oldest first
+	size     int64
+	events   int
+	seq      uint64
+
+	drainMu sync.Mutex
+	// backing off after a failed replay, guarded by drainMu
+	failures    int
+	nextAttempt time.Time
+}
+
+type spoolSegment struct {
+	seq    uint64
+	size   int64
+	events int // one per line
+}
+
+func countEvents(data []byte) int {
+	return bytes.Count(data, []byte("\n"))
+}
+
+// openSpool opens (or creates) the spool directory and indexes any segments
+// left over from a previous run.
+func openSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spool{dir: dir, maxBytes: maxBytes}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, spoolTempExt) {
+			// a write that never completed
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, ok := parseSegmentName(name)
+		if !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		seg := spoolSegment{seq: seq, size: info.Size()}
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil {
+			seg.events = countEvents(data)
+		}
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+		s.events += seg.events
+		s.seq = max(s.seq, seq)
+	}
+	slices.SortFunc(s.segments, func(a, b spoolSegment) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	return s, nil
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, spoolSegmentExt)
+}
+
+func parseSegmentName(name string) (uint64, bool) {
+	base, ok := strings.CutSuffix(name, spoolSegmentExt)
+	if !ok {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(base, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+func (s *spool) path(seg spoolSegment) string {
+	return filepath.Join(s.dir, segmentName(seg.seq))
+}
+
+// write appends body as a new segment. If the spool would grow beyond
+// maxBytes, the oldest segments are discarded to make room; it returns the
+// number of events they held, even if the write then fails.
+func (s *spool) write(body []byte) (evicted int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(len(body))
+	if s.maxBytes > 0 && size > s.maxBytes {
+		return 0, errSpoolFull
+	}
+	for s.maxBytes > 0 && s.size+size > s.maxBytes && len(s.segments) > 0 {
+		oldest := s.segments[0]
+		if err := os.Remove(s.path(oldest)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return evicted, err
+		}
+		s.segments = s.segments[1:]
+		s.size -= oldest.size
+		s.events -= oldest.events
+		evicted += oldest.events
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial segment behind.
+	tmp, err := os.CreateTemp(s.dir, "segment-*"+spoolTempExt)
+	if err != nil {
+		return evicted, err
+	}
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return evicted, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return evicted, err
+	}
+
+	seg := spoolSegment{seq: s.seq + 1, size: size, events: countEvents(body)}
+	if err := os.Rename(tmp.Name(), s.path(seg)); err != nil {
+		os.Remove(tmp.Name())
+		return evicted, err
+	}
+	s.seq = seg.seq
+	s.segments = append(s.segments, seg)
+	s.size += size
+	s.events += seg.events
+	return evicted, nil
+}
+
+// oldest returns the oldest segment, if there is one.
+func (s *spool) oldest() (spoolSegment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 {
+		return spoolSegment{}, false
+	}
+	return s.segments[0], true
+}
+
+// read returns the contents of a segment.
+func (s *spool) read(seg spoolSegment) ([]byte, error) {
+	return os.ReadFile(s.path(seg))
+}
+
+// remove deletes a segment after it has been delivered.
+func (s *spool) remove(seg spoolSegment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := slices.Index(s.segments, seg)
+	if idx < 0 {
+		return // already evicted
+	}
+	_ = os.Remove(s.path(seg))
+	s.segments = slices.Delete(s.segments, idx, idx+1)
+	s.size -= seg.size
+	s.events -= seg.events
+}
+
+// len returns the number of segments waiting to be replayed.
+func (s *spool) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments)
+}
+
+// pending returns the number of events waiting to be replayed. A nil spool
+// has none.
+func (s *spool) pending() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events
+}
+
+// drainSpool replays spooled segments, oldest first, until the spool is
+// empty or Seq fails to take a batch. Segments Seq rejects for good are
+// dropped, so they don't hold up the ones behind them; after any other
+// failure, the spool isn't replayed again until the retry policy's delay has
+// passed. Only one worker drains at a time.
+func (h *SeqHandler) drainSpool(ctx context.Context, w *worker) {
+	if h.spool == nil || !h.spool.drainMu.TryLock() {
+		return
+	}
+	defer h.spool.drainMu.Unlock()
+	if time.Now().Before(h.spool.nextAttempt) {
+		return
+	}
+	_ = h.replaySpool(ctx, w)
+}
+
+// flushSpool replays the spool for Flush and Shutdown, without waiting for
+// the backoff to expire. If another worker is replaying it, it waits for that
+// worker first. It returns the error that stopped the replay, if any.
+func (h *SeqHandler) flushSpool(ctx context.Context, w *worker) error {
+	if h.spool == nil {
+		return nil
+	}
+	h.spool.drainMu.Lock()
+	defer h.spool.drainMu.Unlock()
+	return h.replaySpool(ctx, w)
+}
+
+// replaySpool sends the spooled segments, oldest first, with drainMu held.
+func (h *SeqHandler) replaySpool(ctx context.Context, w *worker) error {
+	for {
+		seg, ok := h.spool.oldest()
+		if !ok {
+			return nil
+		}
+		data, err := h.spool.read(seg)
+		if err != nil {
+			// it will never be readable, forget about it and move on
+			h.reportError(fmt.Errorf("slogseq: reading spool segment: %w", err))
+			h.recordDropped(w, dropUndeliverable, seg.events)
+			h.spool.remove(seg)
+			continue
+		}
+		// A segment holds a single batch, but it is still split up if Seq
+		// finds it too large. If only part of it gets through, the whole
+		// segment is sent again later.
+		lines := bytes.SplitAfter(data, []byte("\n"))
+		if last := len(lines) - 1; len(lines[last]) == 0 {
+			lines = lines[:last]
+		}
+		entries := make([]entry, len(lines))
+		for i, line := range lines {
+			entries[i].line = line
+			// segments don't keep which events are spans
+			entries[i].span = h.protocol == ProtocolOTLP && isSpanLine(line)
+		}
+		n, err := h.postEntries(ctx, w, entries)
+		if err != nil && h.retryPolicy.retryable(err) {
+			h.spool.failures++
+			var retryAfter time.Duration
+			if se, ok := err.(*sendError); ok {
+				retryAfter = se.retryAfter
+			}
+			h.spool.nextAttempt = time.Now().Add(h.retryPolicy.delay(h.spool.failures, retryAfter))
+			return err
+		}
+		if err != nil {
+			h.recordDropped(w, dropUndeliverable, len(lines)-n)
+		}
+		h.spool.failures = 0
+		h.spool.remove(seg)
+	}
+}
@@ -0,0 +1,276 @@
+package slogseq
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool_FailedBatchIsReplayed(t *testing.T) {
+	seq := newFakeSeq(503)
+
+	s, err := openSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	handler := &SeqHandler{
+		client: seq.client(),
+		seqURL: "http://example.com",
+		spool:  s,
+	}
+	w := &worker{}
+
+	leftover := handler.sendWithRetry(context.Background(), w, testEntries(t, CLEFEvent{Message: "first", Timestamp: time.Now()}))
+	assert.Nil(t, leftover, "failed batch should be spooled instead of kept in memory")
+	leftover = handler.sendWithRetry(context.Background(), w, testEntries(t, CLEFEvent{Message: "second", Timestamp: time.Now()}))
+	assert.Nil(t, leftover)
+	assert.Equal(t, 2, s.len())
+	assert.Empty(t, w.retryBuffer)
+
+	seq.setStatus(200)
+
+	leftover = handler.sendWithRetry(context.Background(), w, testEntries(t, CLEFEvent{Message: "third", Timestamp: time.Now()}))
+	assert.Nil(t, leftover)
+	assert.Equal(t, 0, s.len(), "spool should be drained after a successful send")
+
+	bodies := seq.bodies()
+	require.Len(t, bodies, 3)
+	assert.Contains(t, bodies[0], "third")
+	assert.Contains(t, bodies[1], "first")
+	assert.Contains(t, bodies[2], "second")
+}
+
+// writeSegment writes body to the spool as a segment.
+func writeSegment(t *testing.T, s *spool, body string) {
+	t.Helper()
+	_, err := s.write([]byte(body))
+	require.NoError(t, err)
+}
+
+func TestSpool_MaxBytesDiscardsOldest(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openSpool(dir, 10)
+	require.NoError(t, err)
+
+	writeSegment(t, s, "aaaa\n")
+	writeSegment(t, s, "bbbb\n")
+	evicted, err := s.write([]byte("cccc\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, evicted)
+	assert.Equal(t, 2, s.len())
+
+	seg, ok := s.oldest()
+	require.True(t, ok)
+	data, err := s.read(seg)
+	require.NoError(t, err)
+	assert.Equal(t, "bbbb\n", string(data))
+
+	_, err = s.write([]byte(strings.Repeat("x", 11)))
+	assert.ErrorIs(t, err, errSpoolFull)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestSpool_LeftoverSegmentsDrainedAtStart(t *testing.T) {
+	dir := t.TempDir()
+	// a spool left behind by a previous process, including an unfinished write
+	previous, err := openSpool(dir, 0)
+	require.NoError(t, err)
+	writeSegment(t, previous, `{"@m":"one"}`+"\n")
+	writeSegment(t, previous, `{"@m":"two"}`+"\n")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "segment-1"+spoolTempExt), []byte("{"), 0o644))
+
+	seq := newFakeSeq(200)
+
+	_, handler := NewLogger("http://example.com",
+		WithHTTPClient(seq.client()),
+		WithSpoolDir(dir, 0),
+	)
+	require.NoError(t, handler.Close())
+	bodies := seq.bodies()
+	require.Len(t, bodies, 2)
+	assert.Contains(t, bodies[0], "one")
+	assert.Contains(t, bodies[1], "two")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSpool_ReplayedWhileIdle(t *testing.T) {
+	seq := newFakeSeq(503)
+	logger, handler := NewLogger("http://example.com",
+		WithHTTPClient(seq.client()),
+		WithSpoolDir(t.TempDir(), 0),
+		WithFlushInterval(10*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{BaseDelay: time.Millisecond}),
+	)
+	defer handler.Close()
+
+	logger.Info("spooled")
+	require.Eventually(t, func() bool { return handler.spool.len() == 1 }, time.Second, 5*time.Millisecond)
+
+	// nothing else is logged, the ticker replays the spool on its own
+	seq.setStatus(201)
+	require.Eventually(t, func() bool { return handler.spool.len() == 0 }, time.Second, 5*time.Millisecond)
+	require.Len(t, seq.bodies(), 1)
+	assert.Contains(t, seq.bodies()[0], "spooled")
+}
+
+func TestSpool_FlushWaitsForTheSpool(t *testing.T) {
+	seq := newFakeSeq(503)
+	logger, handler := NewLogger("http://example.com",
+		WithHTTPClient(seq.client()),
+		WithSpoolDir(t.TempDir(), 0),
+		WithFlushInterval(time.Hour),
+	)
+	defer handler.Close()
+
+	logger.Info("spooled")
+	var derr *DeliveryError
+	require.ErrorAs(t, handler.Flush(context.Background()), &derr)
+	assert.Equal(t, 0, derr.Undelivered)
+	assert.Equal(t, 1, derr.Spooled, "events on disk are not delivered yet")
+
+	seq.setStatus(201)
+	require.NoError(t, handler.Flush(context.Background()))
+	assert.Zero(t, handler.spool.pending())
+	require.Len(t, seq.bodies(), 1)
+	assert.Contains(t, seq.bodies()[0], "spooled")
+}
+
+func TestSpool_PermanentlyRejectedSegmentIsDropped(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+	writeSegment(t, s, `{"@m":"bad"}`+"\n")
+	writeSegment(t, s, `{"@m":"good"}`+"\n")
+
+	seq := newFakeSeq(201)
+	seq.respond = func(req *http.Request, body []byte) (int, error) {
+		if strings.Contains(string(body), "bad") {
+			return 400, nil
+		}
+		return 201, nil
+	}
+	handler := &SeqHandler{
+		client:      seq.client(),
+		seqURL:      "http://example.com",
+		spool:       s,
+		retryPolicy: DefaultRetryPolicy(),
+	}
+	w := &worker{}
+
+	handler.drainSpool(context.Background(), w)
+	assert.Equal(t, 0, s.len(), "a rejected segment must not hold up the ones behind it")
+	assert.Equal(t, uint64(1), w.stats.droppedUndeliverable.Load())
+	assert.Equal(t, []string{`{"@m":"good"}` + "\n"}, seq.bodies())
+
+	handler.drainSpool(context.Background(), w)
+	assert.Equal(t, 2, seq.calls(), "a rejected segment isn't sent again")
+}
+
+func TestSpool_BacksOffAfterFailedReplay(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+	writeSegment(t, s, `{"@m":"spooled"}`+"\n")
+
+	seq := newFakeSeq(201)
+	seq.respond = func(req *http.Request, body []byte) (int, error) {
+		if strings.Contains(string(body), "spooled") {
+			return 503, nil
+		}
+		return 201, nil
+	}
+	handler := &SeqHandler{
+		client:      seq.client(),
+		seqURL:      "http://example.com",
+		spool:       s,
+		retryPolicy: RetryPolicy{BaseDelay: time.Hour},
+	}
+	w := &worker{}
+
+	for range 5 {
+		leftover := handler.sendWithRetry(context.Background(), w, testEntries(t, CLEFEvent{Message: "live", Timestamp: time.Now()}))
+		assert.Nil(t, leftover)
+	}
+	assert.Equal(t, 6, seq.calls(), "the spool is replayed again only after the retry delay")
+	assert.Equal(t, 1, s.len(), "a transient failure keeps the segment")
+}
+
+func TestSpool_EvictedEventsCountAsDropped(t *testing.T) {
+	batch := func(msg string) []entry {
+		ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		return testEntries(t, CLEFEvent{Message: msg, Timestamp: ts}, CLEFEvent{Message: msg, Timestamp: ts})
+	}
+	// room for a single batch
+	s, err := openSpool(t.TempDir(), int64(len(bytes.Join(entryLines(batch("one")), nil))))
+	require.NoError(t, err)
+
+	handler := &SeqHandler{
+		client: newFakeSeq(503).client(),
+		seqURL: "http://example.com",
+		spool:  s,
+	}
+	w := &worker{}
+
+	assert.Nil(t, handler.sendWithRetry(context.Background(), w, batch("one")))
+	assert.Nil(t, handler.sendWithRetry(context.Background(), w, batch("two")))
+
+	assert.Equal(t, 1, s.len())
+	ws := w.stats.snapshot(0)
+	assert.Equal(t, uint64(4), ws.Spooled)
+	assert.Equal(t, uint64(2), ws.DroppedSpoolFull)
+}
+
+func TestSpool_OpenErrorIsReported(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	var reported []error
+	_, handler := NewLogger("http://example.com",
+		WithHTTPClient(newFakeSeq(201).client()),
+		WithSpoolDir(filepath.Join(file, "spool"), 0),
+		WithErrorHandler(func(err error) { reported = append(reported, err) }),
+	)
+	defer handler.Close()
+
+	assert.Nil(t, handler.spool)
+	require.Error(t, handler.Stats().SpoolError)
+	require.Len(t, reported, 1)
+	assert.Equal(t, handler.Stats().SpoolError, reported[0])
+}
+
+func TestSpool_UnreadableSegmentCountsAsDropped(t *testing.T) {
+	s, err := openSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+	writeSegment(t, s, `{"@m":"one"}`+"\n"+`{"@m":"two"}`+"\n")
+	// a directory in place of the segment can't be read, but can be removed
+	seg, _ := s.oldest()
+	require.NoError(t, os.Remove(s.path(seg)))
+	require.NoError(t, os.Mkdir(s.path(seg), 0o755))
+
+	var reported []error
+	seq := newFakeSeq(201)
+	handler := &SeqHandler{
+		client:       seq.client(),
+		seqURL:       "http://example.com",
+		spool:        s,
+		errorHandler: func(err error) { reported = append(reported, err) },
+	}
+	w := &worker{}
+	handler.drainSpool(context.Background(), w)
+
+	assert.Zero(t, s.len())
+	assert.Zero(t, seq.calls())
+	assert.Equal(t, uint64(2), w.stats.snapshot(0).DroppedUndeliverable)
+	assert.Len(t, reported, 1)
+}
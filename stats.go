@@ -0,0 +1,209 @@
+package slogseq
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// WorkerStats holds the delivery statistics of a single worker.
+type WorkerStats struct {
+	// Enqueued is the number of events accepted into the worker's queue.
+	Enqueued uint64
+	// Sent is the number of events accepted by Seq.
+	Sent uint64
+	// Spooled is the number of events written to the disk spool.
+	Spooled uint64
+	// DroppedQueueFull is the number of events dropped because the queue was full.
+	DroppedQueueFull uint64
+	// DroppedPurged is the number of events purged from the retry buffer
+	// because they were too old.
+	DroppedPurged uint64
+	// DroppedUndeliverable is the number of events dropped because they can
+	// never be delivered: they can't be encoded, are too large, were rejected
+	// by Seq, or ran out of retries.
+	DroppedUndeliverable uint64
+	// DroppedSpoolFull is the number of spooled events discarded to make room
+	// for newer ones once the spool reached its maximum size.
+	DroppedSpoolFull uint64
+	// FailedRequests is the number of requests to Seq that failed.
+	FailedRequests uint64
+	// QueueLength is the number of events currently waiting in the queue,
+	// including the priority queue.
+	QueueLength int
+	// RetryBufferSize is the number of events currently waiting to be retried.
+	RetryBufferSize int
+	// LastError is the error of the last failed request, if any.
+	LastError error
+	// LastErrorTime is when the last request failed.
+	LastErrorTime time.Time
+	// LastSuccess is when Seq last accepted a request.
+	LastSuccess time.Time
+}
+
+// Stats is a snapshot of a handler's delivery statistics.
+type Stats struct {
+	Workers []WorkerStats
+	// MemoryUsed is the estimated size of the events held in memory, if a
+	// limit was set with WithMemoryLimit.
+	MemoryUsed int64
+	// SpoolError is why the spool set with WithSpoolDir could not be opened,
+	// in which case the handler runs without one.
+	SpoolError error
+}
+
+// Total sums up the statistics of all workers. LastError is the most recent
+// error of any worker.
+func (s Stats) Total() WorkerStats {
+	var t WorkerStats
+	for _, w := range s.Workers {
+		t.Enqueued += w.Enqueued
+		t.Sent += w.Sent
+		t.Spooled += w.Spooled
+		t.DroppedQueueFull += w.DroppedQueueFull
+		t.DroppedPurged += w.DroppedPurged
+		t.DroppedUndeliverable += w.DroppedUndeliverable
+		t.DroppedSpoolFull += w.DroppedSpoolFull
+		t.FailedRequests += w.FailedRequests
+		t.QueueLength += w.QueueLength
+		t.RetryBufferSize += w.RetryBufferSize
+		if w.LastErrorTime.After(t.LastErrorTime) {
+			t.LastError = w.LastError
+			t.LastErrorTime = w.LastErrorTime
+		}
+		if w.LastSuccess.After(t.LastSuccess) {
+			t.LastSuccess = w.LastSuccess
+		}
+	}
+	return t
+}
+
+// Stats returns a snapshot of the delivery statistics of every worker.
+func (h *SeqHandler) Stats() Stats {
+	s := Stats{
+		Workers:    make([]WorkerStats, len(h.workers)),
+		MemoryUsed: h.memory.usage(),
+		SpoolError: h.spoolErr,
+	}
+	for i := range h.workers {
+		s.Workers[i] = h.workers[i].stats.snapshot(h.workers[i].queueLength())
+	}
+	return s
+}
+
+// workerStats is updated by the worker and by the goroutines enqueueing
+// events, and read by Stats, so every field is atomic.
+type workerStats struct {
+	enqueued             atomic.Uint64
+	sent                 atomic.Uint64
+	spooled              atomic.Uint64
+	droppedQueueFull     atomic.Uint64
+	droppedPurged        atomic.Uint64
+	droppedUndeliverable atomic.Uint64
+	droppedSpoolFull     atomic.Uint64
+	failedRequests       atomic.Uint64
+	retryBufferSize      atomic.Int64
+	lastError            atomic.Pointer[errorRecord]
+	lastSuccess          atomic.Int64 // unix nanoseconds
+}
+
+type errorRecord struct {
+	err error
+	at  time.Time
+}
+
+func (s *workerStats) snapshot(queueLength int) WorkerStats {
+	ws := WorkerStats{
+		Enqueued:             s.enqueued.Load(),
+		Sent:                 s.sent.Load(),
+		Spooled:              s.spooled.Load(),
+		DroppedQueueFull:     s.droppedQueueFull.Load(),
+		DroppedPurged:        s.droppedPurged.Load(),
+		DroppedUndeliverable: s.droppedUndeliverable.Load(),
+		DroppedSpoolFull:     s.droppedSpoolFull.Load(),
+		FailedRequests:       s.failedRequests.Load(),
+		QueueLength:          queueLength,
+		RetryBufferSize:      int(s.retryBufferSize.Load()),
+	}
+	if rec := s.lastError.Load(); rec != nil {
+		ws.LastError = rec.err
+		ws.LastErrorTime = rec.at
+	}
+	if ns := s.lastSuccess.Load(); ns != 0 {
+		ws.LastSuccess = time.Unix(0, ns)
+	}
+	return ws
+}
+
+// queueLength is the number of events waiting in the worker's queues.
+func (w *worker) queueLength() int {
+	return len(w.eventsCh) + len(w.priorityCh)
+}
+
+// undelivered is the number of events the worker has yet to deliver, queued
+// or held in its current batch and retry buffer.
+func (w *worker) undelivered() int {
+	return w.queueLength() + int(w.held.Load())
+}
+
+// hold records the number of events the worker holds besides its queues.
+func (w *worker) hold(events []entry) {
+	w.held.Store(int64(len(events) + len(w.retryBuffer)))
+}
+
+// The record methods below are called with a nil worker when sending outside
+// of a worker, such as in tests.
+
+func (w *worker) recordSent(n int) {
+	if w == nil {
+		return
+	}
+	w.stats.sent.Add(uint64(n))
+	w.stats.lastSuccess.Store(time.Now().UnixNano())
+}
+
+func (w *worker) recordFailedRequest(err error) {
+	if w == nil {
+		return
+	}
+	w.stats.failedRequests.Add(1)
+	w.stats.lastError.Store(&errorRecord{err: err, at: time.Now()})
+}
+
+// dropReason says why events were dropped.
+type dropReason string
+
+const (
+	dropQueueFull     dropReason = "queue_full"
+	dropPurged        dropReason = "purged"
+	dropUndeliverable dropReason = "undeliverable"
+	dropSpoolFull     dropReason = "spool_full"
+)
+
+// recordDropped counts dropped events in the worker's statistics and in the
+// handler's metrics.
+func (h *SeqHandler) recordDropped(w *worker, reason dropReason, n int) {
+	if n <= 0 {
+		return
+	}
+	h.metrics.recordDropped(reason, n)
+	if w == nil {
+		return
+	}
+	switch reason {
+	case dropQueueFull:
+		w.stats.droppedQueueFull.Add(uint64(n))
+	case dropPurged:
+		w.stats.droppedPurged.Add(uint64(n))
+	case dropUndeliverable:
+		w.stats.droppedUndeliverable.Add(uint64(n))
+	case dropSpoolFull:
+		w.stats.droppedSpoolFull.Add(uint64(n))
+	}
+}
+
+func (w *worker) recordSpooled(n int) {
+	if w == nil {
+		return
+	}
+	w.stats.spooled.Add(uint64(n))
+}
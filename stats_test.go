@@ -0,0 +1,105 @@
+package slogseq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_SentAndEnqueued(t *testing.T) {
+	seq := newFakeSeq(201)
+	logger, handler := NewLogger("http://example.com",
+		WithHTTPClient(seq.client()),
+		WithFlushInterval(time.Hour),
+		WithWorkers(2),
+	)
+	defer handler.Close()
+
+	for i := range 4 {
+		logger.Info("counted", "i", i)
+	}
+	require.NoError(t, handler.Flush(context.Background()))
+
+	stats := handler.Stats()
+	require.Len(t, stats.Workers, 2)
+	total := stats.Total()
+	assert.Equal(t, uint64(4), total.Enqueued)
+	assert.Equal(t, uint64(4), total.Sent)
+	assert.Zero(t, total.FailedRequests)
+	assert.Nil(t, total.LastError)
+	assert.False(t, total.LastSuccess.IsZero())
+	for _, w := range stats.Workers {
+		assert.Equal(t, uint64(2), w.Sent, "events should be spread over the workers")
+	}
+}
+
+func TestStats_DroppedQueueFull(t *testing.T) {
+	handler := &SeqHandler{
+		workers: []worker{{eventsCh: make(chan entry, 1)}},
+	}
+
+	handler.HandleCLEFEvent(CLEFEvent{Message: "kept"})
+	handler.HandleCLEFEvent(CLEFEvent{Message: "dropped"})
+
+	ws := handler.Stats().Workers[0]
+	assert.Equal(t, uint64(1), ws.Enqueued)
+	assert.Equal(t, uint64(1), ws.DroppedQueueFull)
+	assert.Equal(t, 1, ws.QueueLength)
+}
+
+func TestStats_FailuresAndErrorHandler(t *testing.T) {
+	var reported []error
+	handler := &SeqHandler{
+		client:       newFakeSeq(400).client(),
+		seqURL:       "http://example.com",
+		errorHandler: func(err error) { reported = append(reported, err) },
+	}
+	w := &worker{}
+
+	leftover := handler.sendWithRetry(context.Background(), w, testEntries(t, CLEFEvent{Message: "a"}, CLEFEvent{Message: "b"}))
+	assert.Nil(t, leftover)
+
+	ws := w.stats.snapshot(0)
+	assert.Equal(t, uint64(1), ws.FailedRequests)
+	assert.Equal(t, uint64(2), ws.DroppedUndeliverable)
+	assert.Zero(t, ws.Sent)
+	require.Error(t, ws.LastError)
+	assert.False(t, ws.LastErrorTime.IsZero())
+
+	require.Len(t, reported, 1)
+	var se *sendError
+	require.True(t, errors.As(reported[0], &se))
+	assert.Equal(t, 400, se.statusCode)
+}
+
+func TestStats_DroppedPurged(t *testing.T) {
+	now := time.Now()
+	handler := &SeqHandler{}
+	w := &worker{retryBuffer: testEntries(t,
+		CLEFEvent{Message: "old", Timestamp: now.Add(-time.Hour)},
+		CLEFEvent{Message: "new", Timestamp: now},
+	)}
+
+	handler.purgeOldEvents(w, now.Add(-5*time.Minute))
+	assert.Equal(t, uint64(1), w.stats.snapshot(0).DroppedPurged)
+}
+
+func TestStats_Total(t *testing.T) {
+	older := time.Now().Add(-time.Minute)
+	newer := time.Now()
+	errOld, errNew := errors.New("old"), errors.New("new")
+
+	total := Stats{Workers: []WorkerStats{
+		{Sent: 1, RetryBufferSize: 2, LastError: errNew, LastErrorTime: newer, LastSuccess: older},
+		{Sent: 3, RetryBufferSize: 4, LastError: errOld, LastErrorTime: older, LastSuccess: newer},
+	}}.Total()
+
+	assert.Equal(t, uint64(4), total.Sent)
+	assert.Equal(t, 6, total.RetryBufferSize)
+	assert.Equal(t, errNew, total.LastError)
+	assert.Equal(t, newer, total.LastSuccess)
+}
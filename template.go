@@ -0,0 +1,125 @@
+package slogseq
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// templateHole is a property reference in a message template, such as
+// {UserId}, {@User} or {Elapsed,8:%.2f}.
+type templateHole struct {
+	raw    string // the hole as written, including the braces
+	name   string
+	format string
+}
+
+// parseTemplateHoles returns the holes of a message template in order of
+// appearance. Doubled braces are literal text; anything between braces that
+// is not a valid property reference is left alone, as Seq does.
+func parseTemplateHoles(template string) []templateHole {
+	var holes []templateHole
+	for i := 0; i < len(template); i++ {
+		if template[i] != '{' {
+			continue
+		}
+		if i+1 < len(template) && template[i+1] == '{' {
+			i++ // escaped brace
+			continue
+		}
+		end := strings.IndexByte(template[i:], '}')
+		if end < 0 {
+			break
+		}
+		raw := template[i : i+end+1]
+		if hole, ok := parseHole(raw); ok {
+			holes = append(holes, hole)
+			i += end
+		}
+	}
+	return holes
+}
+
+func parseHole(raw string) (templateHole, bool) {
+	hole := templateHole{raw: raw}
+	content := raw[1 : len(raw)-1]
+	content, hole.format, _ = strings.Cut(content, ":")
+	content, alignment, hasAlignment := strings.Cut(content, ",")
+	content = strings.TrimPrefix(strings.TrimPrefix(content, "@"), "$")
+
+	if content == "" || !isTemplateName(content) {
+		return hole, false
+	}
+	if hasAlignment && !isAlignment(alignment) {
+		return hole, false
+	}
+	hole.name = content
+	return hole, true
+}
+
+func isTemplateName(s string) bool {
+	for _, c := range s {
+		if !(c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlignment(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// templateRenderings renders the holes that have a format specifier, in
+// order, as CLEF expects in @r. Formats are fmt verbs, the leading % being
+// optional, e.g. {Elapsed:.2f}. lookup returns the property a hole refers to;
+// holes without a matching property are rendered as written.
+func templateRenderings(holes []templateHole, lookup func(name string) (any, bool)) []string {
+	var renderings []string
+	for _, hole := range holes {
+		if hole.format == "" {
+			continue
+		}
+		v, ok := lookup(hole.name)
+		if !ok {
+			renderings = append(renderings, hole.raw)
+			continue
+		}
+		verb := hole.format
+		if !strings.HasPrefix(verb, "%") {
+			verb = "%" + verb
+		}
+		rendered := fmt.Sprintf(verb, v)
+		if strings.Contains(rendered, "%!") {
+			// not a format fmt understands
+			rendered = fmt.Sprint(v)
+		}
+		renderings = append(renderings, rendered)
+	}
+	return renderings
+}
+
+// eventTypeID computes the @i event type of a message template, using the
+// same Jenkins one-at-a-time hash over UTF-16 code units as Serilog, so
+// events from Go and .NET services share event types.
+func eventTypeID(template string) string {
+	var hash uint32
+	for _, c := range utf16.Encode([]rune(template)) {
+		hash += uint32(c)
+		hash += hash << 10
+		hash ^= hash >> 6
+	}
+	hash += hash << 3
+	hash ^= hash >> 11
+	hash += hash << 15
+	return fmt.Sprintf("%08x", hash)
+}
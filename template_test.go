@@ -0,0 +1,62 @@
+package slogseq
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplateHoles(t *testing.T) {
+	holes := parseTemplateHoles("User {UserId} logged in from {@Client} after {Elapsed,8:.2f} ms {{not a hole}} {not valid} {$Name}")
+	require.Len(t, holes, 4)
+	assert.Equal(t, "UserId", holes[0].name)
+	assert.Equal(t, "Client", holes[1].name)
+	assert.Equal(t, "Elapsed", holes[2].name)
+	assert.Equal(t, ".2f", holes[2].format)
+	assert.Equal(t, "{Elapsed,8:.2f}", holes[2].raw)
+	assert.Equal(t, "Name", holes[3].name)
+
+	assert.Empty(t, parseTemplateHoles("no holes here"))
+	assert.Empty(t, parseTemplateHoles("unterminated {hole"))
+	assert.Len(t, parseTemplateHoles("{a {b}"), 1, "a brace inside text should not hide the hole after it")
+}
+
+func TestTemplateRenderings(t *testing.T) {
+	holes := parseTemplateHoles("{Plain} took {Elapsed:.2f} ms, {Missing:d} and {Code:%04d} {Odd:zz}")
+	props := map[string]any{"Plain": "x", "Elapsed": 12.3456, "Code": 42, "Odd": "y"}
+	lookup := func(name string) (any, bool) {
+		v, ok := props[name]
+		return v, ok
+	}
+
+	assert.Equal(t, []string{"12.35", "{Missing:d}", "0042", "y"}, templateRenderings(holes, lookup))
+	assert.Nil(t, templateRenderings(parseTemplateHoles("{Plain}"), lookup), "@r only holds formatted holes")
+}
+
+func TestEventTypeID(t *testing.T) {
+	id := eventTypeID("User {UserId} logged in")
+	assert.Len(t, id, 8)
+	assert.Equal(t, id, eventTypeID("User {UserId} logged in"), "event type should be stable")
+	assert.NotEqual(t, id, eventTypeID("User {UserId} logged out"))
+	assert.Equal(t, "00000000", eventTypeID(""))
+}
+
+func TestSeqHandler_MessageTemplates(t *testing.T) {
+	handler := newQueueingHandler(WithMessageTemplates())
+	defer handler.Close()
+
+	logger := slog.New(handler)
+	logger.Info("User {UserId} logged in after {Elapsed:.1f} s", "UserId", 42, "Elapsed", 1.25)
+
+	e := <-handler.workers[0].eventsCh
+	evt := decodeEntry(t, e)
+	assert.Empty(t, evt.Message)
+	assert.Equal(t, "User {UserId} logged in after {Elapsed:.1f} s", evt.MessageTemplate)
+	assert.Equal(t, []string{"1.2"}, evt.Renderings)
+	assert.Equal(t, eventTypeID(evt.MessageTemplate), evt.EventType)
+
+	assert.NotContains(t, string(e.line), `"@m"`, "@m and @mt are alternatives")
+	assert.Equal(t, float64(42), evt.Properties["UserId"])
+}